@@ -5,38 +5,67 @@ import (
 	"flag"
 	"log"
 	"net/http"
-	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/actor"
 	"github.com/kiryu-dev/segments-api/internal/config"
+	"github.com/kiryu-dev/segments-api/internal/metrics"
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	idempotency_repo "github.com/kiryu-dev/segments-api/internal/repository/idempotency"
+	jobs_repo "github.com/kiryu-dev/segments-api/internal/repository/jobs"
+	keys_repo "github.com/kiryu-dev/segments-api/internal/repository/keys"
 	logs_repo "github.com/kiryu-dev/segments-api/internal/repository/logs"
 	"github.com/kiryu-dev/segments-api/internal/repository/postgres"
 	segment_repo "github.com/kiryu-dev/segments-api/internal/repository/segment"
 	user_repo "github.com/kiryu-dev/segments-api/internal/repository/user"
+	"github.com/kiryu-dev/segments-api/internal/scheduler"
+	idempotency_service "github.com/kiryu-dev/segments-api/internal/service/idempotency"
+	jobs_service "github.com/kiryu-dev/segments-api/internal/service/jobs"
+	keys_service "github.com/kiryu-dev/segments-api/internal/service/keys"
 	"github.com/kiryu-dev/segments-api/internal/service/logs"
 	logs_service "github.com/kiryu-dev/segments-api/internal/service/logs"
 	"github.com/kiryu-dev/segments-api/internal/service/segment"
 	segment_service "github.com/kiryu-dev/segments-api/internal/service/segment"
 	user_service "github.com/kiryu-dev/segments-api/internal/service/user"
+	"github.com/kiryu-dev/segments-api/internal/tracing"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/jobs/get_job"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/keys/create_key"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/keys/revoke_key"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/logs/get_logs"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers/logs/get_user_logs"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers/segment/create_segment"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers/segment/delete_segment"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/segment/get_segment"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/segment/get_segment_users"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/segment/get_segments"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/segment/restore_segment"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/segment/update_segment"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers/user/change_user_segments"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/user/change_user_segments_bulk"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers/user/create_user"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers/user/delete_user"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers/user/get_user_segments"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers/user/update_user_segment"
+	"github.com/kiryu-dev/segments-api/internal/transport/middleware"
 
 	_ "github.com/kiryu-dev/segments-api/docs"
 	httpSwagger "github.com/swaggo/http-swagger"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //	@title		Segments API
 //	@version	1.0
 //	@host		localhost:8080
 //	@BasePath	/
+//
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						X-API-Key
 
 func main() {
 	var configPath string
@@ -54,17 +83,58 @@ func main() {
 		return
 	}
 	defer db.Close()
+	migrator, err := postgres.NewMigrator(db)
+	if err != nil {
+		log.Printf("unexpected migrations error: %v", err)
+		return
+	}
+	if flag.Arg(0) == "migrate" {
+		sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := migrate(sigCtx, migrator, flag.Args()[1:]); err != nil {
+			// non-zero exit code lets scripts stop before starting the server
+			log.Fatalf("migration failed: %v", err)
+		}
+		return
+	}
+	if err := checkSchema(migrator); err != nil {
+		log.Printf("%v, run `segments migrate up` first", err)
+		return
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), &cfg.Tracing)
+	if err != nil {
+		log.Printf("cannot set up tracing: %v", err)
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("cannot flush traces: %v", err)
+		}
+	}()
+	repository.Observe(observeStatement)
+	jobService, err := jobs_service.New(jobs_repo.New(db), cfg.Workers, cfg.QueueSize, cfg.Lease)
+	if err != nil {
+		log.Printf("cannot create jobs: %v", err)
+		return
+	}
 	var (
 		/* repository layer */
 		logRepo     = logs_repo.New(db)
 		userRepo    = user_repo.New(db)
 		segmentRepo = segment_repo.New(db)
+		keyRepo     = keys_repo.New(db)
+		idemRepo    = idempotency_repo.New(db)
+		transactor  = postgres.NewTransactor(db)
 		/* service layer */
 		logService     = logs_service.New(logRepo)
-		userService    = user_service.New(userRepo, logRepo)
-		segmentService = segment_service.New(segmentRepo, userRepo, logRepo)
+		keyService     = keys_service.New(keyRepo)
+		idemService    = idempotency_service.New(idemRepo, cfg.Window, cfg.LockTimeout)
+		userService    = user_service.New(userRepo, segmentRepo, logRepo, transactor, jobService)
+		segmentService = segment_service.New(segmentRepo, userRepo, logRepo, transactor, jobService)
 		/* transport layer */
-		router = setupRoutes(segmentService, userService, logService)
+		router = setupRoutes(segmentService, userService, logService, jobService, keyService, idemService)
 		server = &http.Server{
 			Addr:         cfg.Address,
 			Handler:      router,
@@ -72,14 +142,61 @@ func main() {
 			ReadTimeout:  cfg.Timeout,
 			IdleTimeout:  cfg.IdleTimeout,
 		}
+		metricsServer = &http.Server{
+			Addr:         cfg.MetricsAddress,
+			Handler:      metrics.Handler(),
+			WriteTimeout: cfg.Timeout,
+			ReadTimeout:  cfg.Timeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		}
 	)
-	go func() {
-		for {
-			if err := segmentService.DeleteByTTL(); err != nil {
-				log.Println(err)
-			}
-			time.Sleep(1 * time.Minute)
+	metrics.RegisterMembers(segmentRepo)
+	if cfg.BootstrapKey != "" {
+		if err := keyService.Bootstrap(context.Background(), cfg.BootstrapKeyName, cfg.BootstrapKey); err != nil {
+			log.Printf("cannot create bootstrap api key: %v", err)
 		}
+	}
+	if err := jobService.Start(); err != nil {
+		log.Printf("cannot start jobs: %v", err)
+		return
+	}
+	defer jobService.Stop()
+	sweeper := scheduler.New(postgres.NewAdvisoryLock(db, cfg.LockKey), cfg.Interval,
+		&scheduler.Task{
+			Name: "activate scheduled segments",
+			Run: func(ctx context.Context) error {
+				_, err := segmentService.ActivateScheduled(ctx, cfg.BatchSize)
+				return err
+			},
+		},
+		&scheduler.Task{
+			Name: "delete expired segments",
+			Run: func(ctx context.Context) error {
+				_, err := segmentService.DeleteByTTL(ctx, cfg.BatchSize)
+				return err
+			},
+		},
+		&scheduler.Task{
+			Name: "purge deleted segments",
+			Run: func(ctx context.Context) error {
+				_, err := segmentService.Purge(ctx, cfg.Retention)
+				return err
+			},
+		},
+		&scheduler.Task{
+			Name: "purge expired idempotency keys",
+			Run: func(ctx context.Context) error {
+				_, err := idemService.Purge(ctx)
+				return err
+			},
+		},
+	)
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		sweeper.Run(actor.With(sigCtx, actor.System))
 	}()
 	go func() {
 		log.Println("server is starting...")
@@ -87,34 +204,77 @@ func main() {
 			log.Printf("failed to start server: %v", err)
 		}
 	}()
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil {
+			log.Printf("failed to start metrics server: %v", err)
+		}
+	}()
+	<-sigCtx.Done()
 	log.Println("gracefully shutting down...")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("failed to shutdown server: %v", err)
 	}
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		log.Printf("failed to shutdown metrics server: %v", err)
+	}
+	<-sweeperDone
 }
 
-func setupRoutes(segment *segment.Service, user *user_service.Service, log *logs.Service) *mux.Router {
-	router := mux.NewRouter()
+// observeStatement records latency of a statement made by the repository
+// method and wraps it in a client span.
+func observeStatement(ctx context.Context, repo, method, query string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, repo+"."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBStatement(query)))
+	return ctx, func(err error) {
+		metrics.DBDuration.WithLabelValues(repo, method).Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}
+}
+
+func setupRoutes(segment *segment.Service, user *user_service.Service, log *logs.Service,
+	jobs *jobs_service.Service, keys *keys_service.Service, idem *idempotency_service.Service) *mux.Router {
+	var (
+		router = mux.NewRouter()
+		auth   = func(scope model.Scope, handler http.Handler) http.Handler {
+			return middleware.Auth(keys, scope)(handler)
+		}
+		idempotent = middleware.Idempotency(idem)
+	)
+	{
+		router.Handle("/segment", auth(model.ScopeSegmentsWrite, idempotent(create_segment.New(segment)))).Methods(http.MethodPost)
+		router.Handle("/segment", auth(model.ScopeSegmentsRead, get_segments.New(segment))).Methods(http.MethodGet)
+		router.Handle("/segment/{slug}", auth(model.ScopeSegmentsRead, get_segment.New(segment))).Methods(http.MethodGet)
+		router.Handle("/segment/{slug}", auth(model.ScopeSegmentsWrite, update_segment.New(segment))).Methods(http.MethodPatch)
+		router.Handle("/segment/{slug}", auth(model.ScopeSegmentsWrite, delete_segment.New(segment))).Methods(http.MethodDelete)
+		router.Handle("/segment/{slug}/restore", auth(model.ScopeSegmentsWrite, restore_segment.New(segment))).Methods(http.MethodPost)
+		router.Handle("/segment/{slug}/users", auth(model.ScopeSegmentsRead, get_segment_users.New(segment))).Methods(http.MethodGet)
+	}
+	{
+		router.Handle("/user", auth(model.ScopeSegmentsWrite, idempotent(create_user.New(user)))).Methods(http.MethodPost)
+		router.Handle("/user/{userID}", auth(model.ScopeSegmentsWrite, delete_user.New(user))).Methods(http.MethodDelete)
+		router.Handle("/user-segments", auth(model.ScopeSegmentsWrite, idempotent(change_user_segments.New(user)))).Methods(http.MethodPost)
+		router.Handle("/user-segments/bulk", auth(model.ScopeSegmentsWrite, change_user_segments_bulk.New(user))).Methods(http.MethodPost)
+		router.Handle("/user-segments/{userID}", auth(model.ScopeSegmentsRead, get_user_segments.New(user))).Methods(http.MethodGet)
+		router.Handle("/user-segments/{userID}/{slug}", auth(model.ScopeSegmentsWrite, update_user_segment.New(user))).Methods(http.MethodPatch)
+	}
 	{
-		router.HandleFunc("/segment", create_segment.New(segment)).Methods(http.MethodPost)
-		router.HandleFunc("/segment/{slug}", delete_segment.New(segment)).Methods(http.MethodDelete)
+		router.Handle("/log", auth(model.ScopeLogsRead, get_logs.New(log))).Methods(http.MethodGet)
+		router.Handle("/log/{userID}", auth(model.ScopeLogsRead, get_user_logs.New(log))).Methods(http.MethodGet)
 	}
 	{
-		router.HandleFunc("/user", create_user.New(user)).Methods(http.MethodPost)
-		router.HandleFunc("/user/{userID}", delete_user.New(user)).Methods(http.MethodDelete)
-		router.HandleFunc("/user-segments", change_user_segments.New(user)).Methods(http.MethodPost)
-		router.HandleFunc("/user-segments/{userID}", get_user_segments.New(user)).Methods(http.MethodGet)
+		router.Handle("/jobs/{id}", auth(model.ScopeSegmentsRead, get_job.New(jobs))).Methods(http.MethodGet)
 	}
 	{
-		router.HandleFunc("/log/{userID}", get_user_logs.New(log)).Methods(http.MethodGet)
+		router.Handle("/keys", auth(model.ScopeAdmin, create_key.New(keys))).Methods(http.MethodPost)
+		router.Handle("/keys/{id}", auth(model.ScopeAdmin, revoke_key.New(keys))).Methods(http.MethodDelete)
 	}
 	{
 		router.PathPrefix("/docs/").Handler(httpSwagger.WrapHandler)
 	}
+	router.Use(middleware.Metrics, middleware.Tracing)
 	return router
 }
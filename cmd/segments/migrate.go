@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/repository/postgres"
+)
+
+const migrateUsage = "usage: segments [-config path] migrate up|down [steps]|status"
+
+// migrate runs the migrate subcommand: up applies all pending migrations,
+// down reverts the given number of the latest ones (1 by default) and status
+// prints all migrations with their application time.
+func migrate(ctx context.Context, migrator *postgres.Migrator, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			log.Printf("applied migration %d_%s", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			log.Println("database schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("steps should be a positive number")
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			log.Printf("reverted migration %d_%s", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		migrations, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			status := "pending"
+			if migration.AppliedAt != nil {
+				status = "applied at " + migration.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", migration.Version, migration.Name, status)
+		}
+		return nil
+	}
+	return errors.New(migrateUsage)
+}
+
+// checkSchema refuses to start the server against a database with pending
+// migrations.
+func checkSchema(migrator *postgres.Migrator) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return migrator.Check(ctx)
+}
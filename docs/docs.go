@@ -16,35 +16,36 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/log/{userID}": {
+        "/jobs/{id}": {
             "get": {
-                "description": "Получение истории добавления и удаления сегментов указанного пользователя за определенный год и месяц в формате CSV.",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод получения состояния фоновой задачи (добавление сегмента проценту пользователей, массовое изменение сегментов пользователей, удаление сегмента): статус, общее количество элементов, количество обработанных и завершившихся ошибкой элементов, а также список ошибок.",
                 "produces": [
-                    "application/octet-stream"
+                    "application/json"
                 ],
                 "tags": [
-                    "logs"
+                    "jobs"
                 ],
-                "summary": "Получить историю изменения сегментов пользователя",
+                "summary": "Получить состояние фоновой задачи",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "user id",
-                        "name": "userID",
+                        "description": "job id",
+                        "name": "id",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "type": "string",
-                        "format": "year-month",
-                        "description": "filter date",
-                        "name": "date",
-                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "job state",
+                        "schema": {
+                            "$ref": "#/definitions/model.Job"
+                        }
                     },
                     "400": {
                         "description": "error",
@@ -52,6 +53,24 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.responseError"
                         }
                     },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "404": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
                     "500": {
                         "description": "error",
                         "schema": {
@@ -67,9 +86,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/segment": {
+        "/keys": {
             "post": {
-                "description": "Метод создания сегмента. Принимает slug (название) сегмента. Опционально можно указать процент пользователей, которые добавятся в этот сегмент автоматически.",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод создания API ключа. Принимает имя ключа, его пространство имен (namespace) и список прав: segments:read (чтение сегментов), segments:write (изменение сегментов и пользователей), logs:read (чтение истории) и admin (все права и управление ключами). Сам ключ возвращается только в ответе на этот запрос, в базе данных хранится лишь его хеш. Ключ с правом segments:write может изменять только сегменты своего пространства имен, читать можно любые сегменты. Имя ключа записывается в историю изменений как инициатор изменения.",
                 "consumes": [
                     "application/json"
                 ],
@@ -77,25 +101,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "segment"
+                    "keys"
                 ],
-                "summary": "Создать новый сегмент",
+                "summary": "Создать API ключ",
                 "parameters": [
                     {
-                        "description": "segment name and user percentage (optional)",
+                        "description": "key name, namespace and scopes",
                         "name": "input",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/create_segment.request"
+                            "$ref": "#/definitions/create_key.request"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "(optional) segment name and added users",
+                    "201": {
+                        "description": "created key",
                         "schema": {
-                            "$ref": "#/definitions/create_segment.response"
+                            "$ref": "#/definitions/create_key.response"
                         }
                     },
                     "400": {
@@ -104,6 +128,24 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.responseError"
                         }
                     },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "409": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
                     "500": {
                         "description": "error",
                         "schema": {
@@ -119,21 +161,26 @@ const docTemplate = `{
                 }
             }
         },
-        "/segment/{slug}": {
+        "/keys/{id}": {
             "delete": {
-                "description": "Метод удаления сегмента. Принимает slug (название) сегмента.",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод отзыва API ключа по его id. Запросы с отозванным ключом отклоняются, записи в истории изменений сохраняют его имя.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "segment"
+                    "keys"
                 ],
-                "summary": "Удалить сегмент",
+                "summary": "Отозвать API ключ",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "segment name",
-                        "name": "slug",
+                        "type": "integer",
+                        "description": "key id",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
@@ -148,50 +195,19 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.responseError"
                         }
                     },
-                    "500": {
+                    "401": {
                         "description": "error",
                         "schema": {
                             "$ref": "#/definitions/handlers.responseError"
                         }
                     },
-                    "default": {
+                    "403": {
                         "description": "error",
                         "schema": {
                             "$ref": "#/definitions/handlers.responseError"
                         }
-                    }
-                }
-            }
-        },
-        "/user": {
-            "post": {
-                "description": "Метод создания пользователя. Принимает на вход id пользователя.",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "user"
-                ],
-                "summary": "Создать нового пользователя",
-                "parameters": [
-                    {
-                        "description": "user id",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/create_user.request"
-                        }
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK"
                     },
-                    "400": {
+                    "404": {
                         "description": "error",
                         "schema": {
                             "$ref": "#/definitions/handlers.responseError"
@@ -212,35 +228,77 @@ const docTemplate = `{
                 }
             }
         },
-        "/user-segments": {
-            "post": {
-                "description": "Метод изменения активных сегментов пользователя. Принимает список slug (названий) сегментов которые нужно добавить пользователю, список slug (названий) сегментов которые нужно удалить у пользователя, id пользователя. Также есть возможность задать TTL для добавляемых сегментов, чтобы по истечению времени они автоматически удалились у пользователя. TTL задается в формате \"1y8m21d\". Если хотите только удалить определенные сегменты, то можно опустить список для добавления и наоборот.",
-                "consumes": [
-                    "application/json"
+        "/log": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
                 ],
+                "description": "Метод получения истории добавления и удаления сегментов в порядке времени изменения с постраничной навигацией. Интервал задается границами from (включительно) и to (не включительно) в формате RFC3339, историю можно отфильтровать по пользователю, сегменту (по текущему названию или названию на момент изменения) и типу операции. Для получения следующей страницы нужно передать next_cursor из предыдущего ответа в параметре cursor.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "logs"
                 ],
-                "summary": "Изменить сегменты пользователя",
+                "summary": "Получить историю изменения сегментов",
                 "parameters": [
                     {
-                        "description": "user id, segment's list to add (with ttl optional), segment's list to delete",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/change_user_segments.request"
-                        }
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "start of interval (inclusive)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "end of interval (exclusive)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "segment name",
+                        "name": "slug",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "add",
+                            "delete"
+                        ],
+                        "type": "string",
+                        "description": "operation type",
+                        "name": "operation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor from previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 50,
+                        "description": "page size (1-1000)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "list of changes",
+                        "description": "list of logs",
                         "schema": {
-                            "$ref": "#/definitions/change_user_segments.response"
+                            "$ref": "#/definitions/get_logs.response"
                         }
                     },
                     "400": {
@@ -249,6 +307,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.responseError"
                         }
                     },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
                     "500": {
                         "description": "error",
                         "schema": {
@@ -264,16 +334,24 @@ const docTemplate = `{
                 }
             }
         },
-        "/user-segments/{userID}": {
+        "/log/{userID}": {
             "get": {
-                "description": "Метод получения активных сегментов пользователя. Принимает на вход id пользователя.",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Получение истории добавления и удаления сегментов указанного пользователя за определенный год и месяц. Формат выгрузки выбирается параметром format или заголовком Accept: CSV с заголовком (по умолчанию), JSON массив, NDJSON или Parquet. Записи передаются по мере чтения из базы данных, без промежуточных файлов.",
                 "produces": [
-                    "application/json"
+                    "text/csv",
+                    "application/json",
+                    "application/x-ndjson",
+                    "application/vnd.apache.parquet"
                 ],
                 "tags": [
-                    "user"
+                    "logs"
                 ],
-                "summary": "Получить активные сегменты пользователя",
+                "summary": "Получить историю изменения сегментов пользователя",
                 "parameters": [
                     {
                         "type": "integer",
@@ -281,19 +359,50 @@ const docTemplate = `{
                         "name": "userID",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "format": "year-month",
+                        "description": "filter date",
+                        "name": "date",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "csv",
+                            "json",
+                            "ndjson",
+                            "parquet"
+                        ],
+                        "type": "string",
+                        "description": "export format",
+                        "name": "format",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "list of segments",
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "error",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/handlers.responseError"
                         }
                     },
-                    "400": {
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "406": {
                         "description": "error",
                         "schema": {
                             "$ref": "#/definitions/handlers.responseError"
@@ -314,28 +423,48 @@ const docTemplate = `{
                 }
             }
         },
-        "/user/{userID}": {
-            "delete": {
-                "description": "Метод удаления пользователя. Принимает на вход id пользователя.",
+        "/segment": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод получения списка сегментов в алфавитном порядке с постраничной навигацией. Для каждого сегмента возвращаются время создания, процент пользователей, количество участников и статистика по TTL. Для получения следующей страницы нужно передать next_cursor из предыдущего ответа в параметре cursor.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "segment"
                 ],
-                "summary": "Удалить пользователя",
+                "summary": "Получить список сегментов",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "segment name prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor from previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
-                        "description": "user id",
-                        "name": "userID",
-                        "in": "path",
-                        "required": true
+                        "default": 50,
+                        "description": "page size (1-1000)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "list of segments",
+                        "schema": {
+                            "$ref": "#/definitions/get_segments.response"
+                        }
                     },
                     "400": {
                         "description": "error",
@@ -343,6 +472,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.responseError"
                         }
                     },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
                     "500": {
                         "description": "error",
                         "schema": {
@@ -356,22 +497,1326 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        }
-    },
-    "definitions": {
-        "change_user_segments.request": {
-            "type": "object",
-            "properties": {
-                "to_add": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/change_user_segments.segmentWithTTL"
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
                     }
-                },
-                "to_delete": {
-                    "type": "array",
-                    "items": {
+                ],
+                "description": "Метод создания сегмента. Принимает slug (название) сегмента. Опционально можно указать процент пользователей, которые добавятся в этот сегмент автоматически. В режиме rollout=hash пользователи выбираются детерминированно по хэшу id, а новые пользователи автоматически попадают в сегмент при создании. Можно указать описание (description), список тегов (tags) и состояние (state): draft или active, по умолчанию active. Сегмент в состоянии draft не добавляется пользователям автоматически, пока его не активируют. Можно задать TTL по умолчанию (default_ttl в формате \"1y8m21d\"), который применяется при каждом добавлении сегмента пользователю без явного TTL. Владельцем сегмента становится пространство имен API ключа, изменять сегмент и его участников могут только ключи этого пространства имен; другого владельца (owner) может указать только ключ с правом admin. С флагом async пользователи добавляются в фоновой задаче: метод сразу возвращает id задачи, ее прогресс можно узнать через GET /jobs/{id}.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Создать новый сегмент",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "retries with the same key within idempotency window replay the first response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "description": "segment name, metadata, user percentage, rollout mode, default ttl and async flag (optional)",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/create_segment.request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "(optional) segment name and added users",
+                        "schema": {
+                            "$ref": "#/definitions/create_segment.response"
+                        }
+                    },
+                    "202": {
+                        "description": "segment name and id of the job adding users",
+                        "schema": {
+                            "$ref": "#/definitions/create_segment.response"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "409": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "422": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "503": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/{slug}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод получения информации о сегменте: время создания, процент пользователей и режим их выбора, количество участников, количество участников с TTL, ближайшее и самое позднее время истечения TTL. Принимает slug (название) сегмента.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Получить информацию о сегменте",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "segment name",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "segment info",
+                        "schema": {
+                            "$ref": "#/definitions/model.SegmentStats"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "404": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод удаления сегмента. Принимает slug (название) сегмента. Сегмент вместе с составом участников можно восстановить через POST /segment/{slug}/restore в течение времени хранения удаленных сегментов, после чего он удаляется окончательно. Удалить сегмент может только ключ из пространства имен его владельца или ключ с правом admin. С параметром async=true сегмент удаляется в фоновой задаче: метод сразу возвращает id задачи, ее прогресс можно узнать через GET /jobs/{id}.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Удалить сегмент",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "segment name",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "delete in background job",
+                        "name": "async",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "202": {
+                        "description": "id of the job deleting segment",
+                        "schema": {
+                            "$ref": "#/definitions/delete_segment.asyncResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "503": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод изменения сегмента. Принимает текущий slug (название) сегмента и в body изменяемые поля: новое название, описание (description), список тегов (tags), состояние (state) и владельца (owner); не указанные поля не меняются. При переименовании участники сегмента и их TTL сохраняются, записи истории продолжают относиться к сегменту: в них возвращается как текущее название сегмента (slug), так и название на момент изменения (original_slug). При активации сегмента в состоянии draft он добавляется заданному проценту пользователей в фоновой задаче: метод возвращает id задачи, ее прогресс можно узнать через GET /jobs/{id}; вернуть активированный сегмент в draft нельзя. Сегмент в состоянии archived нельзя добавить пользователям, но его текущие участники и история сохраняются. Передать сегмент другому пространству имен может только ключ с правом admin.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Изменить сегмент",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "segment name",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "fields to change",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/update_segment.request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "updated segment",
+                        "schema": {
+                            "$ref": "#/definitions/update_segment.response"
+                        }
+                    },
+                    "202": {
+                        "description": "activated segment and id of the job adding users",
+                        "schema": {
+                            "$ref": "#/definitions/update_segment.response"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "404": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "409": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "503": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/{slug}/restore": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод восстановления удаленного сегмента. Принимает slug (название) сегмента. Сегмент возвращается всем пользователям, которые состояли в нем на момент удаления, если их TTL не истек, а сами пользователи не удалены. Восстановить сегмент можно в течение времени хранения удаленных сегментов, после чего они удаляются окончательно. Название удаленного сегмента можно сразу использовать для нового сегмента, восстанавливается последний удаленный сегмент с этим названием; если название уже занято, восстановить его нельзя.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Восстановить сегмент",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "segment name",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "users the segment is restored to",
+                        "schema": {
+                            "$ref": "#/definitions/restore_segment.response"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "404": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "409": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/{slug}/users": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод получения пользователей, состоящих в сегменте, в порядке возрастания id с постраничной навигацией. Для получения следующей страницы нужно передать next_cursor из предыдущего ответа (или заголовка X-Next-Cursor для CSV) в параметре cursor. Можно оставить только пользователей с TTL (или без него) и пользователей, у которых сегмент истекает раньше указанного времени.",
+                "produces": [
+                    "application/json",
+                    "text/csv"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Получить участников сегмента",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "segment name",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "next_cursor from previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 50,
+                        "description": "page size (1-1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "only users with (true) or without (false) ttl",
+                        "name": "has_ttl",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "format": "date-time",
+                        "description": "only users whose ttl expires before",
+                        "name": "expires_before",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv"
+                        ],
+                        "type": "string",
+                        "default": "json",
+                        "description": "response format",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "list of users",
+                        "schema": {
+                            "$ref": "#/definitions/get_segment_users.response"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "404": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод создания пользователя. Принимает на вход id пользователя. Пользователь автоматически добавляется в сегменты, созданные с процентом пользователей, если попадает в выборку.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Создать нового пользователя",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "retries with the same key within idempotency window replay the first response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "description": "user id",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/create_user.request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "user id and segments the user was added to",
+                        "schema": {
+                            "$ref": "#/definitions/create_user.response"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "409": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "422": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user-segments": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод изменения активных сегментов пользователя. Принимает список slug (названий) сегментов которые нужно добавить пользователю, список slug (названий) сегментов которые нужно удалить у пользователя, id пользователя. Также есть возможность задать TTL для добавляемых сегментов, чтобы по истечению времени они автоматически удалились у пользователя. TTL задается в формате \"1y8m21d\", вместо него можно указать точное время истечения expires_at в формате RFC3339. Если TTL не указан, применяется TTL сегмента по умолчанию (если он задан). Добавление сегмента можно запланировать на будущее, указав время начала starts_at в формате RFC3339: до этого момента сегмент не возвращается в списке сегментов пользователя, а TTL отсчитывается от времени начала. Если хотите только удалить определенные сегменты, то можно опустить список для добавления и наоборот. С флагом atomic все изменения применяются в одной транзакции: при ошибке хотя бы одного изменения ни одно из них не применяется. Параметр on_conflict задает поведение при добавлении сегмента, который у пользователя уже есть: error (по умолчанию) возвращает ошибку, ignore оставляет сегмент без изменений, refresh заменяет время его удаления на заданное в запросе TTL (или TTL сегмента по умолчанию); в истории такое добавление не записывается. Сегменты в состоянии archived добавить нельзя, но их можно удалить у пользователя.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Изменить сегменты пользователя",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "retries with the same key within idempotency window replay the first response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "description": "user id, segment's list to add (with ttl optional), segment's list to delete, atomic flag and conflict policy (optional)",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/change_user_segments.request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "list of changes",
+                        "schema": {
+                            "$ref": "#/definitions/change_user_segments.response"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "409": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "422": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user-segments/bulk": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод добавления и удаления сегментов сразу у множества пользователей (до 100000 за запрос). Принимает JSON со списком id пользователей, списком сегментов для добавления (с TTL или временем истечения expires_at опционально) и списком сегментов для удаления. Также можно отправить multipart/form-data с CSV файлом users (id пользователей в первом столбце) и полями to_add и to_delete в формате JSON. В ответе для каждого сегмента указывается результат и количество затронутых пользователей, а для каждого пользователя — добавленные, удаленные и пропущенные (пользователь уже состоял или не состоял в сегменте, либо не существует) сегменты. С флагом async изменения применяются в фоновой задаче: метод сразу возвращает id задачи, ее прогресс можно узнать через GET /jobs/{id}.",
+                "consumes": [
+                    "application/json",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Изменить сегменты множества пользователей",
+                "parameters": [
+                    {
+                        "description": "user ids, segment's list to add (with ttl optional), segment's list to delete, async flag (optional)",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/change_user_segments_bulk.request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "summary of changes",
+                        "schema": {
+                            "$ref": "#/definitions/change_user_segments_bulk.response"
+                        }
+                    },
+                    "202": {
+                        "description": "id of the job applying changes",
+                        "schema": {
+                            "$ref": "#/definitions/change_user_segments_bulk.asyncResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "503": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user-segments/{userID}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод получения активных сегментов пользователя. Принимает на вход id пользователя. С параметром detailed=true для каждого сегмента возвращаются также время его добавления пользователю и время истечения TTL.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Получить активные сегменты пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "userID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "return assignment and expiry time of segments",
+                        "name": "detailed",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "list of segments (list of model.UserSegment if detailed)",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user-segments/{userID}/{slug}": {
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод изменения времени истечения сегмента пользователя без его удаления и повторного добавления. Нужно указать ровно одно из полей: ttl — новый TTL от текущего момента в формате \"1y8m21d\", expires_at — точное время истечения в формате RFC3339, extend_by — продление текущего TTL (или от текущего момента, если TTL не задан) в формате \"1y8m21d\", clear — убрать TTL.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Изменить TTL сегмента пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "userID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "segment name",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "new ttl, expiry time, ttl extension or clear flag",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/update_user_segment.request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "updated segment of user",
+                        "schema": {
+                            "$ref": "#/definitions/model.UserSegment"
+                        }
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "404": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{userID}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Метод удаления пользователя. Принимает на вход id пользователя.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Удалить пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "userID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "401": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "403": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "500": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.responseError"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "change_user_segments.request": {
+            "type": "object",
+            "properties": {
+                "atomic": {
+                    "type": "boolean"
+                },
+                "on_conflict": {
+                    "default": "error",
+                    "enum": [
+                        "error",
+                        "ignore",
+                        "refresh"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.ConflictPolicy"
+                        }
+                    ]
+                },
+                "to_add": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/change_user_segments.segmentWithTTL"
+                    }
+                },
+                "to_delete": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "change_user_segments.response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "operation_type": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "status_code": {
+                    "type": "integer"
+                }
+            }
+        },
+        "change_user_segments.segmentWithTTL": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "starts_at": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "string"
+                }
+            }
+        },
+        "change_user_segments_bulk.asyncResponse": {
+            "type": "object",
+            "properties": {
+                "job_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "change_user_segments_bulk.request": {
+            "type": "object",
+            "properties": {
+                "async": {
+                    "type": "boolean"
+                },
+                "to_add": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/change_user_segments_bulk.segmentWithTTL"
+                    }
+                },
+                "to_delete": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "user_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "change_user_segments_bulk.response": {
+            "type": "object",
+            "properties": {
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/change_user_segments_bulk.segmentResponse"
+                    }
+                },
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/change_user_segments_bulk.userResponse"
+                    }
+                }
+            }
+        },
+        "change_user_segments_bulk.segmentResponse": {
+            "type": "object",
+            "properties": {
+                "affected": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "operation_type": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "status_code": {
+                    "type": "integer"
+                }
+            }
+        },
+        "change_user_segments_bulk.segmentWithTTL": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "string"
+                }
+            }
+        },
+        "change_user_segments_bulk.userResponse": {
+            "type": "object",
+            "properties": {
+                "added": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "deleted": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "skipped": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "create_key.request": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "namespace": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Scope"
+                    }
+                }
+            }
+        },
+        "create_key.response": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "namespace": {
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Scope"
+                    }
+                }
+            }
+        },
+        "create_segment.request": {
+            "type": "object",
+            "properties": {
+                "async": {
+                    "type": "boolean"
+                },
+                "default_ttl": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "percentage": {
+                    "type": "number"
+                },
+                "rollout": {
+                    "default": "random",
+                    "enum": [
+                        "random",
+                        "hash"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.RolloutMode"
+                        }
+                    ]
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "state": {
+                    "default": "active",
+                    "enum": [
+                        "draft",
+                        "active"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.SegmentState"
+                        }
+                    ]
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "create_segment.response": {
+            "type": "object",
+            "properties": {
+                "job_id": {
+                    "type": "integer"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "users_id": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "create_user.request": {
+            "type": "object",
+            "properties": {
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "create_user.response": {
+            "type": "object",
+            "properties": {
+                "segments": {
+                    "type": "array",
+                    "items": {
                         "type": "string"
                     }
                 },
@@ -380,16 +1825,60 @@ const docTemplate = `{
                 }
             }
         },
-        "change_user_segments.response": {
+        "delete_segment.asyncResponse": {
             "type": "object",
             "properties": {
-                "message": {
+                "job_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "get_logs.response": {
+            "type": "object",
+            "properties": {
+                "logs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.UserLog"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                }
+            }
+        },
+        "get_segment_users.response": {
+            "type": "object",
+            "properties": {
+                "next_cursor": {
                     "type": "string"
                 },
-                "operation_type": {
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.UserSegment"
+                    }
+                }
+            }
+        },
+        "get_segments.response": {
+            "type": "object",
+            "properties": {
+                "next_cursor": {
                     "type": "string"
                 },
-                "slug": {
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.SegmentStats"
+                    }
+                }
+            }
+        },
+        "handlers.responseError": {
+            "type": "object",
+            "properties": {
+                "message": {
                     "type": "string"
                 },
                 "status_code": {
@@ -397,29 +1886,244 @@ const docTemplate = `{
                 }
             }
         },
-        "change_user_segments.segmentWithTTL": {
+        "model.ConflictPolicy": {
+            "type": "string",
+            "enum": [
+                "error",
+                "ignore",
+                "refresh"
+            ],
+            "x-enum-varnames": [
+                "ConflictError",
+                "ConflictIgnore",
+                "ConflictRefresh"
+            ]
+        },
+        "model.Job": {
             "type": "object",
             "properties": {
-                "slug": {
+                "created_at": {
                     "type": "string"
                 },
-                "ttl": {
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.JobError"
+                    }
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "processed": {
+                    "type": "integer"
+                },
+                "status": {
+                    "$ref": "#/definitions/model.JobStatus"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "updated_at": {
                     "type": "string"
                 }
             }
         },
-        "create_segment.request": {
+        "model.JobError": {
+            "type": "object",
+            "properties": {
+                "item": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.JobStatus": {
+            "type": "string",
+            "enum": [
+                "pending",
+                "running",
+                "done",
+                "failed"
+            ],
+            "x-enum-varnames": [
+                "JobPending",
+                "JobRunning",
+                "JobDone",
+                "JobFailed"
+            ]
+        },
+        "model.LogReason": {
+            "type": "string",
+            "enum": [
+                "api",
+                "rollout",
+                "schedule",
+                "ttl",
+                "segment_deleted",
+                "segment_restored",
+                "user_deleted"
+            ],
+            "x-enum-varnames": [
+                "ReasonAPI",
+                "ReasonRollout",
+                "ReasonSchedule",
+                "ReasonTTL",
+                "ReasonSegmentDeleted",
+                "ReasonSegmentRestored",
+                "ReasonUserDeleted"
+            ]
+        },
+        "model.RolloutMode": {
+            "type": "string",
+            "enum": [
+                "random",
+                "hash"
+            ],
+            "x-enum-varnames": [
+                "RandomRollout",
+                "HashRollout"
+            ]
+        },
+        "model.Scope": {
+            "type": "string",
+            "enum": [
+                "segments:read",
+                "segments:write",
+                "logs:read",
+                "admin"
+            ],
+            "x-enum-varnames": [
+                "ScopeSegmentsRead",
+                "ScopeSegmentsWrite",
+                "ScopeLogsRead",
+                "ScopeAdmin"
+            ]
+        },
+        "model.SegmentState": {
+            "type": "string",
+            "enum": [
+                "draft",
+                "active",
+                "archived"
+            ],
+            "x-enum-varnames": [
+                "DraftState",
+                "ActiveState",
+                "ArchivedState"
+            ]
+        },
+        "model.SegmentStats": {
             "type": "object",
             "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "default_ttl": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_expiry": {
+                    "type": "string"
+                },
+                "members": {
+                    "type": "integer"
+                },
+                "members_with_ttl": {
+                    "type": "integer"
+                },
+                "next_expiry": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
                 "percentage": {
                     "type": "number"
                 },
+                "rollout": {
+                    "$ref": "#/definitions/model.RolloutMode"
+                },
                 "slug": {
                     "type": "string"
+                },
+                "state": {
+                    "$ref": "#/definitions/model.SegmentState"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 }
             }
         },
-        "create_segment.response": {
+        "model.UserLog": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "operation": {
+                    "type": "string"
+                },
+                "original_slug": {
+                    "description": "OriginalSlug is the name of the segment at the time of the change,\nSlug is its current name.",
+                    "type": "string"
+                },
+                "reason": {
+                    "$ref": "#/definitions/model.LogReason"
+                },
+                "request_time": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.UserSegment": {
+            "type": "object",
+            "properties": {
+                "assign_time": {
+                    "type": "string"
+                },
+                "delete_time": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "start_time": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "restore_segment.response": {
             "type": "object",
             "properties": {
                 "slug": {
@@ -433,25 +2137,115 @@ const docTemplate = `{
                 }
             }
         },
-        "create_user.request": {
+        "update_segment.request": {
             "type": "object",
             "properties": {
-                "user_id": {
-                    "type": "integer"
+                "description": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "state": {
+                    "enum": [
+                        "draft",
+                        "active",
+                        "archived"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.SegmentState"
+                        }
+                    ]
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 }
             }
         },
-        "handlers.responseError": {
+        "update_segment.response": {
             "type": "object",
             "properties": {
-                "message": {
+                "created_at": {
                     "type": "string"
                 },
-                "status_code": {
+                "default_ttl": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "job_id": {
+                    "type": "integer"
+                },
+                "last_expiry": {
+                    "type": "string"
+                },
+                "members": {
+                    "type": "integer"
+                },
+                "members_with_ttl": {
                     "type": "integer"
+                },
+                "next_expiry": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "percentage": {
+                    "type": "number"
+                },
+                "rollout": {
+                    "$ref": "#/definitions/model.RolloutMode"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "state": {
+                    "$ref": "#/definitions/model.SegmentState"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "update_user_segment.request": {
+            "type": "object",
+            "properties": {
+                "clear": {
+                    "type": "boolean"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "extend_by": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "string"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        }
     }
 }`
 
@@ -0,0 +1,45 @@
+// Package actor carries whoever initiated a request through the context, so
+// services can check their permissions and record them in the audit log.
+package actor
+
+import "context"
+
+type Actor struct {
+	Name string
+	// Namespace is the owner of segments the actor may change.
+	// Segments without an owner are changed by actors without a namespace.
+	Namespace string
+	// Admin may change segments of every namespace.
+	Admin bool
+}
+
+// System is the actor of changes made by the application itself,
+// e.g. by the scheduler.
+var System = &Actor{Name: "system", Admin: true}
+
+type ctxKey struct{}
+
+func With(ctx context.Context, a *Actor) context.Context {
+	return context.WithValue(ctx, ctxKey{}, a)
+}
+
+// From returns the actor stored in ctx or nil if there is none.
+func From(ctx context.Context) *Actor {
+	a, _ := ctx.Value(ctxKey{}).(*Actor)
+	return a
+}
+
+// Name returns name of the actor stored in ctx or an empty string if there is none.
+func Name(ctx context.Context) string {
+	if a := From(ctx); a != nil {
+		return a.Name
+	}
+	return ""
+}
+
+// CanChange reports whether the actor stored in ctx may change segments owned
+// by owner. Requests without an actor are internal and may change anything.
+func CanChange(ctx context.Context, owner string) bool {
+	a := From(ctx)
+	return a == nil || a.Admin || a.Namespace == owner
+}
@@ -10,8 +10,14 @@ import (
 )
 
 type Config struct {
-	HTTPServer `yaml:"http_server"`
-	DB         `yaml:"db"`
+	HTTPServer  `yaml:"http_server"`
+	Metrics     `yaml:"metrics"`
+	DB          `yaml:"db"`
+	Jobs        `yaml:"jobs"`
+	Sweeper     `yaml:"sweeper"`
+	Auth        `yaml:"auth"`
+	Idempotency `yaml:"idempotency"`
+	Tracing     `yaml:"tracing"`
 }
 
 type HTTPServer struct {
@@ -20,14 +26,60 @@ type HTTPServer struct {
 	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"120s"`
 }
 
+// Metrics holds the address Prometheus metrics are served on. It's separate
+// from the API, since metrics aren't protected by API keys and should be
+// reachable only from the internal network.
+type Metrics struct {
+	MetricsAddress string `yaml:"address" env-default:":9090"`
+}
+
 type DB struct {
-	Host         string `yaml:"host" env-default:"postgres"`
-	DBName       string `yaml:"dbname" env-required:"true"`
-	Username     string `yaml:"username" env-required:"true"`
-	Password     string `env:"DB_PASSWORD"`
-	Port         string `yaml:"port" env-default:"5432"`
-	SSLMode      string `yaml:"sslmode" env-default:"disable"`
-	InitFilepath string `yaml:"init_filepath" env-required:"true"`
+	Host     string `yaml:"host" env-default:"postgres"`
+	DBName   string `yaml:"dbname" env-required:"true"`
+	Username string `yaml:"username" env-required:"true"`
+	Password string `env:"DB_PASSWORD"`
+	Port     string `yaml:"port" env-default:"5432"`
+	SSLMode  string `yaml:"sslmode" env-default:"disable"`
+}
+
+type Jobs struct {
+	Workers   int           `yaml:"workers" env-default:"4"`
+	QueueSize int           `yaml:"queue_size" env-default:"64"`
+	Lease     time.Duration `yaml:"lease" env-default:"1m"`
+}
+
+type Sweeper struct {
+	Interval  time.Duration `yaml:"interval" env-default:"1m"`
+	BatchSize int           `yaml:"batch_size" env-default:"1000"`
+	LockKey   int64         `yaml:"lock_key" env-default:"72160"`
+	// Retention is how long deleted segments can be restored before they're purged.
+	Retention time.Duration `yaml:"retention" env-default:"720h"`
+}
+
+// Auth holds the admin key created on startup, so the first keys can be
+// issued through the API. It's skipped if BOOTSTRAP_API_KEY is empty.
+type Auth struct {
+	BootstrapKeyName string `yaml:"bootstrap_key_name" env-default:"bootstrap"`
+	BootstrapKey     string `env:"BOOTSTRAP_API_KEY"`
+}
+
+// Idempotency holds how long responses of requests with Idempotency-Key
+// header are replayed for retries. A request which hasn't finished within
+// LockTimeout is considered lost, e.g. by a crashed instance, and its key
+// may be used again.
+type Idempotency struct {
+	Window      time.Duration `yaml:"window" env-default:"24h"`
+	LockTimeout time.Duration `yaml:"lock_timeout" env-default:"1m"`
+}
+
+// Tracing configures export of OpenTelemetry traces. Exporter is "none",
+// "otlp" sending spans over OTLP/HTTP to Endpoint or "stdout" writing them
+// to File (or to stdout if it's empty) for local use.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env-default:"none"`
+	Endpoint    string  `yaml:"endpoint" env-default:"http://localhost:4318"`
+	File        string  `yaml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio" env-default:"1"`
 }
 
 func LoadConfig(configPath string) (*Config, error) {
@@ -44,9 +96,47 @@ func LoadConfig(configPath string) (*Config, error) {
 	if err := cleanenv.ReadEnv(config); err != nil {
 		return nil, fmt.Errorf("cannot load config: %s", err)
 	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
 	return config, nil
 }
 
+// validate rejects values the service can't run with, e.g. zero interval
+// of the sweeper makes its ticker panic and zero batch size makes it loop
+// forever.
+func (c *Config) validate() error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"sweeper.interval", c.Sweeper.Interval},
+		{"sweeper.retention", c.Retention},
+		{"jobs.lease", c.Lease},
+		{"idempotency.window", c.Window},
+		{"idempotency.lock_timeout", c.LockTimeout},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be positive", d.name)
+		}
+	}
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"sweeper.batch_size", c.BatchSize},
+		{"jobs.workers", c.Workers},
+		{"jobs.queue_size", c.QueueSize},
+	}
+	for _, n := range counts {
+		if n.value <= 0 {
+			return fmt.Errorf("%s must be positive", n.name)
+		}
+	}
+	return nil
+}
+
 func (d DB) String() string {
 	return fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
 		d.Host, d.Port, d.Username, d.DBName, d.Password, d.SSLMode)
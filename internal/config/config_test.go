@@ -0,0 +1,42 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Jobs:        Jobs{Workers: 4, QueueSize: 64, Lease: time.Minute},
+		Sweeper:     Sweeper{Interval: time.Minute, BatchSize: 1000, Retention: 720 * time.Hour},
+		Idempotency: Idempotency{Window: 24 * time.Hour, LockTimeout: time.Minute},
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	type testCase struct {
+		change   func(*Config)
+		expected string
+	}
+	testCases := []testCase{
+		{change: func(c *Config) {}},
+		{change: func(c *Config) { c.Sweeper.Interval = 0 }, expected: "sweeper.interval must be positive"},
+		{change: func(c *Config) { c.Sweeper.BatchSize = 0 }, expected: "sweeper.batch_size must be positive"},
+		{change: func(c *Config) { c.Sweeper.Retention = -time.Hour }, expected: "sweeper.retention must be positive"},
+		{change: func(c *Config) { c.Jobs.Lease = 0 }, expected: "jobs.lease must be positive"},
+		{change: func(c *Config) { c.Jobs.Workers = 0 }, expected: "jobs.workers must be positive"},
+		{change: func(c *Config) { c.Idempotency.LockTimeout = 0 }, expected: "idempotency.lock_timeout must be positive"},
+	}
+	for _, test := range testCases {
+		config := validConfig()
+		test.change(config)
+		err := config.validate()
+		if test.expected == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, test.expected)
+		}
+	}
+}
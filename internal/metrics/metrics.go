@@ -0,0 +1,101 @@
+// Package metrics holds Prometheus metrics of the service. They're registered
+// in the default registry and exposed by Handler.
+package metrics
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "segments"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	DBDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of database queries by repository method.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"repository", "method"})
+	TaskRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduler_task_runs_total",
+		Help:      "Number of scheduled task runs by task and result.",
+	}, []string{"task", "result"})
+	TaskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scheduler_task_duration_seconds",
+		Help:      "Duration of scheduled task runs by task.",
+		Buckets:   prometheus.ExponentialBuckets(.01, 4, 8),
+	}, []string{"task"})
+	ExpiredMemberships = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expired_memberships_total",
+		Help:      "Number of user segments deleted on TTL expiration.",
+	})
+	LogWriteFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "log_write_failures_total",
+		Help:      "Number of audit log entries which couldn't be written by reason of the change.",
+	}, []string{"reason"})
+)
+
+var membersDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "segment_members"),
+	"Number of users in the segment.",
+	[]string{"segment"}, nil,
+)
+
+type memberCounter interface {
+	CountMembers(context.Context) (map[string]uint64, error)
+}
+
+// membersCollector reads member counts of segments from the database on
+// every scrape, so all instances report the same values.
+type membersCollector struct {
+	counter memberCounter
+	timeout time.Duration
+}
+
+// RegisterMembers exposes the number of members of every segment.
+func RegisterMembers(counter memberCounter) {
+	prometheus.MustRegister(&membersCollector{counter, 5 * time.Second})
+}
+
+func (c *membersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- membersDesc
+}
+
+func (c *membersCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	members, err := c.counter.CountMembers(ctx)
+	if err != nil {
+		log.Printf("cannot collect segment members: %v", err)
+		ch <- prometheus.NewInvalidMetric(membersDesc, err)
+		return
+	}
+	for slug, count := range members {
+		ch <- prometheus.MustNewConstMetric(membersDesc, prometheus.GaugeValue, float64(count), slug)
+	}
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
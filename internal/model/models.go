@@ -1,7 +1,7 @@
 package model
 
 import (
-	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -19,19 +19,219 @@ func (o OpType) String() string {
 	return "delete"
 }
 
+type RolloutMode string
+
+const (
+	RandomRollout = RolloutMode("random")
+	HashRollout   = RolloutMode("hash")
+)
+
+// SegmentState is a lifecycle state of a segment. A draft segment is being
+// prepared: users can be added to it by hand, but it isn't rolled out to the
+// percentage of users until it's activated. Users can't be added to archived
+// segments, but their current memberships and history are kept.
+type SegmentState string
+
+const (
+	DraftState    = SegmentState("draft")
+	ActiveState   = SegmentState("active")
+	ArchivedState = SegmentState("archived")
+)
+
+type Segment struct {
+	ID          uint64       `json:"id"`
+	Slug        string       `json:"slug"`
+	Description string       `json:"description,omitempty"`
+	Tags        []string     `json:"tags"`
+	State       SegmentState `json:"state"`
+	Percentage  float64      `json:"percentage"`
+	Rollout     RolloutMode  `json:"rollout"`
+	Salt        string       `json:"-"`
+	DefaultTTL  string       `json:"default_ttl,omitempty"`
+	Owner       string       `json:"owner,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// SegmentUpdate holds fields of a segment to change, nil fields are kept.
+type SegmentUpdate struct {
+	Slug        *string
+	Description *string
+	Tags        *[]string
+	State       *SegmentState
+	Owner       *string
+}
+
+type SegmentStats struct {
+	Segment
+	Members        uint64     `json:"members"`
+	MembersWithTTL uint64     `json:"members_with_ttl"`
+	NextExpiry     *time.Time `json:"next_expiry"`
+	LastExpiry     *time.Time `json:"last_expiry"`
+}
+
+// ConflictPolicy defines what happens when a user is assigned a segment it
+// already has: the assignment fails, is skipped or refreshes delete time.
+type ConflictPolicy string
+
+const (
+	ConflictError   = ConflictPolicy("error")
+	ConflictIgnore  = ConflictPolicy("ignore")
+	ConflictRefresh = ConflictPolicy("refresh")
+)
+
 type UserSegment struct {
-	UserID     uint64     `json:"user_id"`
-	Slug       string     `json:"slug"`
-	DeleteTime *time.Time `json:"delete_time"`
+	UserID     uint64         `json:"user_id"`
+	SegmentID  uint64         `json:"-"`
+	Slug       string         `json:"slug"`
+	DeleteTime *time.Time     `json:"delete_time"`
+	AssignTime *time.Time     `json:"assign_time"`
+	StartTime  *time.Time     `json:"start_time"`
+	OnConflict ConflictPolicy `json:"-"`
+}
+
+type BulkChange struct {
+	Slug       string
+	Operation  OpType
+	DeleteTime *time.Time
+}
+
+type BulkResult struct {
+	Change *BulkChange
+	Users  []uint64
+	Err    error
+}
+
+type MembersFilter struct {
+	Cursor        uint64
+	Limit         int
+	HasTTL        *bool
+	ExpiresBefore *time.Time
+}
+
+type LogsFilter struct {
+	From      *time.Time
+	To        *time.Time
+	UserID    *uint64
+	Slug      string
+	Operation string
+	Cursor    *LogsCursor
+	Limit     int
+}
+
+// LogsCursor points to the last log of the previous page.
+type LogsCursor struct {
+	RequestTime time.Time
+	ID          uint64
+}
+
+type JobStatus string
+
+const (
+	JobPending = JobStatus("pending")
+	JobRunning = JobStatus("running")
+	JobDone    = JobStatus("done")
+	JobFailed  = JobStatus("failed")
+)
+
+type Job struct {
+	ID         uint64      `json:"id"`
+	Kind       string      `json:"kind"`
+	Status     JobStatus   `json:"status"`
+	Total      uint64      `json:"total"`
+	Processed  uint64      `json:"processed"`
+	Failed     uint64      `json:"failed"`
+	Errors     []*JobError `json:"errors"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	FinishedAt *time.Time  `json:"finished_at"`
 }
 
+type JobError struct {
+	Item    string `json:"item"`
+	Message string `json:"message"`
+}
+
+// LogReason tells why a segment of a user was changed.
+type LogReason string
+
+const (
+	ReasonAPI             = LogReason("api")
+	ReasonRollout         = LogReason("rollout")
+	ReasonSchedule        = LogReason("schedule")
+	ReasonTTL             = LogReason("ttl")
+	ReasonSegmentDeleted  = LogReason("segment_deleted")
+	ReasonSegmentRestored = LogReason("segment_restored")
+	ReasonUserDeleted     = LogReason("user_deleted")
+)
+
 type UserLog struct {
-	UserID      uint64    `json:"user_id"`
-	Slug        string    `json:"slug"`
-	Operation   string    `json:"operation"`
-	RequestTime time.Time `json:"request_time"`
+	ID        uint64 `json:"id" parquet:"id"`
+	UserID    uint64 `json:"user_id" parquet:"user_id"`
+	SegmentID uint64 `json:"-" parquet:"-"`
+	Slug      string `json:"slug" parquet:"slug"`
+	// OriginalSlug is the name of the segment at the time of the change,
+	// Slug is its current name.
+	OriginalSlug string    `json:"original_slug" parquet:"original_slug"`
+	Operation    string    `json:"operation" parquet:"operation"`
+	RequestTime  time.Time `json:"request_time" parquet:"request_time,timestamp"`
+	Reason       LogReason `json:"reason" parquet:"reason"`
+	Actor        string    `json:"actor" parquet:"actor"`
+}
+
+func (u UserLog) CSVHeader() []string {
+	return []string{"user_id", "slug", "original_slug", "operation", "request_time", "reason", "actor"}
+}
+
+func (u UserLog) CSVRecord() []string {
+	return []string{
+		strconv.FormatUint(u.UserID, 10),
+		u.Slug,
+		u.OriginalSlug,
+		u.Operation,
+		u.RequestTime.Format(time.RFC3339),
+		string(u.Reason),
+		u.Actor,
+	}
+}
+
+type Scope string
+
+const (
+	ScopeSegmentsRead  = Scope("segments:read")
+	ScopeSegmentsWrite = Scope("segments:write")
+	ScopeLogsRead      = Scope("logs:read")
+	ScopeAdmin         = Scope("admin")
+)
+
+type APIKey struct {
+	ID        uint64     `json:"id"`
+	Name      string     `json:"name"`
+	Namespace string     `json:"namespace,omitempty"`
+	Scopes    []Scope    `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key is granted the scope. Admin keys are
+// granted every scope.
+func (k *APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
 }
 
-func (u UserLog) String() string {
-	return fmt.Sprintf("%d;%s;%s;%v", u.UserID, u.Slug, u.Operation, u.RequestTime)
+// IdempotentRequest is a request made with an Idempotency-Key header and its
+// response, which is replayed for retries. Status is zero while the request
+// is in progress.
+type IdempotentRequest struct {
+	Actor       string
+	Key         string
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
 }
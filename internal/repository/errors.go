@@ -5,11 +5,34 @@ import "fmt"
 var (
 	ErrSegmentExists    = fmt.Errorf("specified segment already exists")
 	ErrSegmentNotExists = fmt.Errorf("specified segment doesn't exist")
+	ErrNotDeleted       = fmt.Errorf("specified segment isn't deleted or is already purged")
+	ErrSlugTaken        = fmt.Errorf("name of specified segment is taken by another segment since its deletion")
+	ErrSegmentArchived  = fmt.Errorf("specified segment is archived")
+	ErrSegmentActivated = fmt.Errorf("specified segment is already activated and can't become a draft")
 )
 
 var (
 	ErrUserExists    = fmt.Errorf("user with specified id already exists")
 	ErrUserNotExists = fmt.Errorf("user with specified id doesn't exist")
 	ErrHasSegment    = fmt.Errorf("user already has specified segment")
+	ErrNoSegment     = fmt.Errorf("user doesn't have specified segment")
 	ErrNoUsers       = fmt.Errorf("there're no users with specified segment")
+	ErrRolledBack    = fmt.Errorf("operation is rolled back because another change in the request failed")
 )
+
+var (
+	ErrJobNotExists = fmt.Errorf("job with specified id doesn't exist")
+	ErrJobQueueFull = fmt.Errorf("too many jobs are in progress, try again later")
+)
+
+var (
+	ErrKeyExists    = fmt.Errorf("api key with specified name already exists")
+	ErrKeyNotExists = fmt.Errorf("api key doesn't exist or is revoked")
+)
+
+var (
+	ErrKeyReused         = fmt.Errorf("idempotency key is already used for another request")
+	ErrRequestInProgress = fmt.Errorf("request with the same idempotency key is in progress")
+)
+
+var ErrForbidden = fmt.Errorf("api key isn't allowed to change segments of another namespace")
@@ -0,0 +1,97 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+)
+
+type repo struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *repo {
+	return &repo{db}
+}
+
+// Reserve stores the request without response unless the actor has already
+// used its key after expiredBefore, or after lockedBefore if the request
+// with the key is still in progress. In that case the stored request is
+// returned and nothing is changed. Otherwise reservation time is set to
+// req.CreatedAt, it identifies the reservation when it's saved or deleted.
+func (r *repo) Reserve(ctx context.Context, req *model.IdempotentRequest,
+	expiredBefore, lockedBefore time.Time) (*model.IdempotentRequest, error) {
+	var (
+		conn  = repository.Conn(ctx, r.db, "idempotency", "Reserve")
+		query = `
+INSERT INTO idempotency_keys (actor, key, fingerprint) VALUES ($1, $2, $3)
+ON CONFLICT (actor, key) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint, status = NULL, content_type = '', body = NULL, created_at = NOW()
+WHERE idempotency_keys.created_at < $4
+OR idempotency_keys.status IS NULL AND idempotency_keys.created_at < $5
+RETURNING created_at;
+		`
+	)
+	err := conn.QueryRowContext(ctx, query, req.Actor, req.Key, req.Fingerprint,
+		expiredBefore, lockedBefore).Scan(&req.CreatedAt)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error reserving idempotency key %s: %v", req.Key, err)
+	}
+	query = `
+SELECT fingerprint, COALESCE(status, 0), content_type, body, created_at FROM idempotency_keys
+WHERE actor = $1 AND key = $2;
+	`
+	stored := &model.IdempotentRequest{Actor: req.Actor, Key: req.Key}
+	err = conn.QueryRowContext(ctx, query, req.Actor, req.Key).Scan(&stored.Fingerprint,
+		&stored.Status, &stored.ContentType, &stored.Body, &stored.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error getting idempotency key %s: %v", req.Key, err)
+	}
+	return stored, nil
+}
+
+// Save stores the response of the reserved request. Nothing is changed if
+// the reservation has timed out and the key has been reserved again by a
+// retry, so a late response doesn't replace the retry's one.
+func (r *repo) Save(ctx context.Context, req *model.IdempotentRequest) error {
+	query := `
+UPDATE idempotency_keys SET status = $3, content_type = $4, body = $5
+WHERE actor = $1 AND key = $2 AND created_at = $6 AND status IS NULL;
+	`
+	_, err := repository.Conn(ctx, r.db, "idempotency", "Save").ExecContext(ctx, query, req.Actor, req.Key,
+		req.Status, req.ContentType, req.Body, req.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error saving response for idempotency key %s: %v", req.Key, err)
+	}
+	return nil
+}
+
+// Delete releases the reserved key unless it's been reserved again by a retry.
+func (r *repo) Delete(ctx context.Context, req *model.IdempotentRequest) error {
+	query := `DELETE FROM idempotency_keys WHERE actor = $1 AND key = $2 AND created_at = $3 AND status IS NULL;`
+	_, err := repository.Conn(ctx, r.db, "idempotency", "Delete").ExecContext(ctx, query, req.Actor, req.Key,
+		req.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error deleting idempotency key %s: %v", req.Key, err)
+	}
+	return nil
+}
+
+// DeleteExpired deletes keys used before the specified time. It returns the
+// number of deleted keys.
+func (r *repo) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	query := `DELETE FROM idempotency_keys WHERE created_at < $1;`
+	res, err := repository.Conn(ctx, r.db, "idempotency", "DeleteExpired").ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %v", err)
+	}
+	count, _ := res.RowsAffected()
+	return int(count), nil
+}
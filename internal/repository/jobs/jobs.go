@@ -0,0 +1,131 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+)
+
+// maxErrors limits the number of item errors stored for a single job.
+const maxErrors = 1000
+
+type repo struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *repo {
+	return &repo{db}
+}
+
+// Create stores a pending job run by the owner instance.
+func (r *repo) Create(ctx context.Context, kind, owner string) (uint64, error) {
+	var (
+		query = `INSERT INTO jobs (kind, status, owner) VALUES ($1, $2, $3) RETURNING id;`
+		id    uint64
+	)
+	err := repository.Conn(ctx, r.db, "jobs", "Create").QueryRowContext(ctx, query, kind, model.JobPending, owner).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating job %s: %v", kind, err)
+	}
+	return id, nil
+}
+
+func (r *repo) Get(ctx context.Context, id uint64) (*model.Job, error) {
+	var (
+		query = `
+SELECT id, kind, status, total, processed, failed, errors, created_at, updated_at, finished_at
+FROM jobs WHERE id = $1;
+		`
+		job    = new(model.Job)
+		errors = make([]byte, 0)
+	)
+	err := repository.Conn(ctx, r.db, "jobs", "Get").QueryRowContext(ctx, query, id).Scan(&job.ID, &job.Kind,
+		&job.Status, &job.Total, &job.Processed, &job.Failed, &errors,
+		&job.CreatedAt, &job.UpdatedAt, &job.FinishedAt)
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrJobNotExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting job %d: %v", id, err)
+	}
+	if err := json.Unmarshal(errors, &job.Errors); err != nil {
+		return nil, fmt.Errorf("error getting job %d: %v", id, err)
+	}
+	return job, nil
+}
+
+func (r *repo) SetStatus(ctx context.Context, id uint64, status model.JobStatus) error {
+	query := `
+UPDATE jobs SET status = $2, updated_at = NOW(),
+finished_at = CASE WHEN $2 IN ('done', 'failed') THEN NOW() END
+WHERE id = $1;
+	`
+	if _, err := repository.Conn(ctx, r.db, "jobs", "SetStatus").ExecContext(ctx, query, id, status); err != nil {
+		return fmt.Errorf("error setting status of job %d: %v", id, err)
+	}
+	return nil
+}
+
+func (r *repo) SetTotal(ctx context.Context, id uint64, total uint64) error {
+	query := `UPDATE jobs SET total = $2, updated_at = NOW() WHERE id = $1;`
+	if _, err := repository.Conn(ctx, r.db, "jobs", "SetTotal").ExecContext(ctx, query, id, total); err != nil {
+		return fmt.Errorf("error setting total of job %d: %v", id, err)
+	}
+	return nil
+}
+
+// AddProgress increases processed and failed counters of the job and appends
+// item errors as long as there're less than maxErrors of them.
+func (r *repo) AddProgress(ctx context.Context, id uint64, processed, failed uint64,
+	errs []*model.JobError) error {
+	query := `
+UPDATE jobs SET processed = processed + $2, failed = failed + $3, updated_at = NOW(),
+errors = CASE WHEN jsonb_array_length(errors) < $5 THEN errors || $4::JSONB ELSE errors END
+WHERE id = $1;
+	`
+	if errs == nil {
+		errs = make([]*model.JobError, 0)
+	}
+	buf, err := json.Marshal(errs)
+	if err != nil {
+		return err
+	}
+	_, err = repository.Conn(ctx, r.db, "jobs", "AddProgress").ExecContext(ctx, query, id, processed, failed, buf, maxErrors)
+	if err != nil {
+		return fmt.Errorf("error updating progress of job %d: %v", id, err)
+	}
+	return nil
+}
+
+// Heartbeat extends the lease of unfinished jobs of the owner instance.
+func (r *repo) Heartbeat(ctx context.Context, owner string) error {
+	query := `UPDATE jobs SET heartbeat_at = NOW() WHERE owner = $1 AND status IN ($2, $3);`
+	_, err := repository.Conn(ctx, r.db, "jobs", "Heartbeat").ExecContext(ctx, query, owner, model.JobPending, model.JobRunning)
+	if err != nil {
+		return fmt.Errorf("error extending lease of jobs: %v", err)
+	}
+	return nil
+}
+
+// FailExpired marks jobs left pending or running by instances which haven't
+// extended their lease for longer than lease as failed, because their tasks
+// are lost. It returns the number of failed jobs.
+func (r *repo) FailExpired(ctx context.Context, lease time.Duration) (int, error) {
+	query := `
+UPDATE jobs SET status = $1, updated_at = NOW(), finished_at = NOW(),
+errors = errors || '[{"item": "", "message": "job is interrupted by service restart"}]'::JSONB
+WHERE status IN ($2, $3) AND heartbeat_at < NOW() - make_interval(secs => $4);
+	`
+	res, err := repository.Conn(ctx, r.db, "jobs", "FailExpired").ExecContext(ctx, query,
+		model.JobFailed, model.JobPending, model.JobRunning, lease.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("error failing expired jobs: %v", err)
+	}
+	count, _ := res.RowsAffected()
+	return int(count), nil
+}
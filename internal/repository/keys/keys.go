@@ -0,0 +1,85 @@
+package keys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/lib/pq"
+)
+
+const uniqueViolation = "23505"
+
+type repo struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *repo {
+	return &repo{db}
+}
+
+// Create stores the key with hash of its token and fills its id and creation time.
+func (r *repo) Create(ctx context.Context, key *model.APIKey, hash string) error {
+	query := `
+INSERT INTO api_keys (name, namespace, hash, scopes) VALUES ($1, $2, $3, $4)
+RETURNING id, created_at;
+	`
+	err := repository.Conn(ctx, r.db, "keys", "Create").QueryRowContext(ctx, query, key.Name, key.Namespace, hash,
+		scopesToArray(key.Scopes)).Scan(&key.ID, &key.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return repository.ErrKeyExists
+	}
+	if err != nil {
+		return fmt.Errorf("error creating api key %s: %v", key.Name, err)
+	}
+	return nil
+}
+
+// GetByHash returns an active key by hash of its token.
+func (r *repo) GetByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	var (
+		query = `
+SELECT id, name, namespace, scopes, created_at FROM api_keys
+WHERE hash = $1 AND revoked_at IS NULL;
+		`
+		key    = new(model.APIKey)
+		scopes = make(pq.StringArray, 0)
+	)
+	err := repository.Conn(ctx, r.db, "keys", "GetByHash").QueryRowContext(ctx, query, hash).
+		Scan(&key.ID, &key.Name, &key.Namespace, &scopes, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrKeyNotExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting api key: %v", err)
+	}
+	key.Scopes = make([]model.Scope, len(scopes))
+	for i, scope := range scopes {
+		key.Scopes[i] = model.Scope(scope)
+	}
+	return key, nil
+}
+
+func (r *repo) Revoke(ctx context.Context, id uint64) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL;`
+	result, err := repository.Conn(ctx, r.db, "keys", "Revoke").ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("error revoking api key %d: %v", id, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return repository.ErrKeyNotExists
+	}
+	return nil
+}
+
+func scopesToArray(scopes []model.Scope) pq.StringArray {
+	result := make(pq.StringArray, len(scopes))
+	for i, scope := range scopes {
+		result[i] = string(scope)
+	}
+	return result
+}
@@ -7,8 +7,16 @@ import (
 	"time"
 
 	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/lib/pq"
 )
 
+// columns of logs joined with segments, so every log resolves to the
+// current name of its segment as well as the name it had at the time.
+const columns = `
+l.id, l.user_id, COALESCE(s.slug, l.slug), l.slug, l.operation, l.request_time, l.reason, l.actor
+`
+
 type repo struct {
 	db *sql.DB
 }
@@ -18,35 +26,116 @@ func New(db *sql.DB) *repo {
 }
 
 func (r *repo) Write(ctx context.Context, log *model.UserLog) error {
-	query := `INSERT INTO logs (user_id, slug, operation, request_time) VALUES ($1, $2, $3, $4);`
-	_, err := r.db.ExecContext(ctx, query, log.UserID, log.Slug, log.Operation, log.RequestTime)
+	query := `
+INSERT INTO logs (user_id, slug, segment_id, operation, request_time, reason, actor)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+	`
+	_, err := repository.Conn(ctx, r.db, "logs", "Write").ExecContext(ctx, query, log.UserID, log.Slug, log.SegmentID,
+		log.Operation, log.RequestTime, log.Reason, log.Actor)
 	if err != nil {
 		return fmt.Errorf("failed to write log of user %d with segment %s: %v", log.UserID, log.Slug, err)
 	}
 	return nil
 }
 
-func (r *repo) Read(ctx context.Context, userID uint64, date time.Time) ([]*model.UserLog, error) {
+// WriteBulk writes the same log for many users at once,
+// UserID of the log is ignored.
+func (r *repo) WriteBulk(ctx context.Context, userIDs []uint64, log *model.UserLog) error {
+	query := `
+INSERT INTO logs (user_id, slug, segment_id, operation, request_time, reason, actor)
+SELECT unnest($1::BIGINT[]), $2, $3, $4, $5, $6, $7;
+	`
+	ids := make(pq.Int64Array, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = int64(id)
+	}
+	_, err := repository.Conn(ctx, r.db, "logs", "WriteBulk").ExecContext(ctx, query, ids, log.Slug, log.SegmentID,
+		log.Operation, log.RequestTime, log.Reason, log.Actor)
+	if err != nil {
+		return fmt.Errorf("failed to write logs of %d users with segment %s: %v", len(userIDs), log.Slug, err)
+	}
+	return nil
+}
+
+// Read calls fn for each log of the user made in the month of the date
+// right while rows are fetched from the database.
+func (r *repo) Read(ctx context.Context, userID uint64, date time.Time, fn func(*model.UserLog) error) error {
 	var (
 		query = `
-SELECT * FROM logs WHERE user_id = $1
-AND EXTRACT(YEAR FROM request_time) = $2
-AND EXTRACT(MONTH FROM request_time) = $3
-ORDER BY request_time;
+SELECT ` + columns + ` FROM logs l LEFT JOIN segment s ON s.id = l.segment_id
+WHERE l.user_id = $1 AND l.request_time >= $2 AND l.request_time < $3
+ORDER BY l.request_time, l.id;
 		`
-		logs = make([]*model.UserLog, 0)
+		from = time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		to   = from.AddDate(0, 1, 0)
 	)
-	rows, err := r.db.QueryContext(ctx, query, userID, date.Year(), int(date.Month()))
+	rows, err := repository.Conn(ctx, r.db, "logs", "Read").QueryContext(ctx, query, userID, from, to)
 	if err != nil {
-		return nil, fmt.Errorf("error getting logs of user %d: %v", userID, err)
+		return fmt.Errorf("error getting logs of user %d: %v", userID, err)
 	}
+	defer rows.Close()
 	for rows.Next() {
-		log := new(model.UserLog)
-		err := rows.Scan(&log.UserID, &log.Slug, &log.Operation, &log.RequestTime)
+		log, err := scanLog(rows)
 		if err != nil {
-			return nil, fmt.Errorf("error getting logs of user %d: %v", userID, err)
+			return fmt.Errorf("error getting logs of user %d: %v", userID, err)
 		}
-		logs = append(logs, log)
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error getting logs of user %d: %v", userID, err)
+	}
+	return nil
+}
+
+// Query returns a page of logs matching the filter ordered by request time.
+func (r *repo) Query(ctx context.Context, filter *model.LogsFilter) ([]*model.UserLog, error) {
+	query := `
+SELECT ` + columns + ` FROM logs l LEFT JOIN segment s ON s.id = l.segment_id
+WHERE ($1::TIMESTAMPTZ IS NULL OR l.request_time >= $1)
+AND ($2::TIMESTAMPTZ IS NULL OR l.request_time < $2)
+AND ($3::BIGINT IS NULL OR l.user_id = $3)
+AND ($4 = '' OR l.slug = $4 OR l.segment_id IN (SELECT id FROM segment WHERE slug = $4))
+AND ($5 = '' OR l.operation = $5)
+AND ($6::TIMESTAMPTZ IS NULL OR (l.request_time, l.id) > ($6, $7))
+ORDER BY l.request_time, l.id LIMIT $8;
+	`
+	var (
+		cursorTime *time.Time
+		cursorID   uint64
+	)
+	if filter.Cursor != nil {
+		cursorTime, cursorID = &filter.Cursor.RequestTime, filter.Cursor.ID
+	}
+	rows, err := repository.Conn(ctx, r.db, "logs", "Query").QueryContext(ctx, query, filter.From, filter.To,
+		filter.UserID, filter.Slug, filter.Operation, cursorTime, cursorID, filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting logs: %v", err)
+	}
+	defer rows.Close()
+	logs, err := scanLogs(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error getting logs: %v", err)
 	}
 	return logs, nil
 }
+
+func scanLogs(rows *sql.Rows) ([]*model.UserLog, error) {
+	logs := make([]*model.UserLog, 0)
+	for rows.Next() {
+		log, err := scanLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+func scanLog(rows *sql.Rows) (*model.UserLog, error) {
+	log := new(model.UserLog)
+	err := rows.Scan(&log.ID, &log.UserID, &log.Slug, &log.OriginalSlug, &log.Operation, &log.RequestTime,
+		&log.Reason, &log.Actor)
+	return log, err
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// AdvisoryLock is a session-level Postgres advisory lock used for leader
+// election: the instance holding it is the leader. The lock is bound to a
+// dedicated connection, so it's released if the connection is lost.
+type AdvisoryLock struct {
+	db   *sql.DB
+	key  int64
+	conn *sql.Conn
+}
+
+func NewAdvisoryLock(db *sql.DB, key int64) *AdvisoryLock {
+	return &AdvisoryLock{db: db, key: key}
+}
+
+// TryAcquire reports whether the lock is held by this instance, trying to
+// take it if it's not. It never blocks waiting for another holder.
+func (l *AdvisoryLock) TryAcquire(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err == nil {
+			return true, nil
+		}
+		// the connection is broken, so the lock is lost together with it
+		_ = l.conn.Close()
+		l.conn = nil
+	}
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("cannot get connection for advisory lock: %v", err)
+	}
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1);`, l.key).Scan(&acquired)
+	if err != nil || !acquired {
+		_ = conn.Close()
+		if err != nil {
+			return false, fmt.Errorf("cannot acquire advisory lock: %v", err)
+		}
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+func (l *AdvisoryLock) Release(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+	defer func() {
+		_ = l.conn.Close()
+		l.conn = nil
+	}()
+	if _, err := l.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1);`, l.key); err != nil {
+		return fmt.Errorf("cannot release advisory lock: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationsLockKey is a key of the advisory lock which prevents several
+// instances from migrating the schema at the same time.
+const migrationsLockKey = 72159
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+var migrationNameRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrSchemaOutdated = errors.New("database schema isn't up to date")
+
+// Migration is a versioned change of the schema. AppliedAt is nil if it's
+// not applied yet.
+type Migration struct {
+	Version   uint64     `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+	up        string
+	down      string
+}
+
+// Migrator applies and reverts migrations embedded into the binary keeping
+// applied versions in schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("cannot load migrations: %v", err)
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Status returns all known migrations ordered by version.
+func (m *Migrator) Status(ctx context.Context) ([]*Migration, error) {
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Migration, len(m.migrations))
+	for i, migration := range m.migrations {
+		status := *migration
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		result[i] = &status
+	}
+	return result, nil
+}
+
+// Check returns ErrSchemaOutdated if some migrations aren't applied.
+func (m *Migrator) Check(ctx context.Context) error {
+	migrations, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, migration := range migrations {
+		if migration.AppliedAt == nil {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d of %d migrations are pending", ErrSchemaOutdated, pending, len(migrations))
+	}
+	return nil
+}
+
+// Up applies all pending migrations in order of their versions, each one in
+// its own transaction. It returns applied migrations.
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	result := make([]*Migration, 0)
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := m.run(ctx, conn, migration.up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("cannot apply migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			result = append(result, migration)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Down reverts the given number of the latest applied migrations. It returns
+// reverted migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	result := make([]*Migration, 0, steps)
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(result) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			err := m.run(ctx, conn, migration.down,
+				`DELETE FROM schema_migrations WHERE version = $1;`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("cannot revert migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			result = append(result, migration)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection for migrations: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1);`, migrationsLockKey); err != nil {
+		return fmt.Errorf("cannot acquire migrations lock: %v", err)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, migrationsLockKey)
+	}()
+	query := `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+	`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("cannot create schema_migrations table: %v", err)
+	}
+	return fn(conn)
+}
+
+// run executes the migration script and records it in a single transaction.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type querier interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}
+
+// applied returns application time of applied migrations by their versions.
+func (m *Migrator) applied(ctx context.Context, q querier) (map[uint64]time.Time, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL;`).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get applied migrations: %v", err)
+	}
+	applied := make(map[uint64]time.Time)
+	if !exists {
+		return applied, nil
+	}
+	rows, err := q.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get applied migrations: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			version   uint64
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("cannot get applied migrations: %v", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// loadMigrations reads migrations from files named like 0001_name.up.sql and
+// 0001_name.down.sql, both of them are required.
+func loadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		match := migrationNameRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version of migration file %s", entry.Name())
+		}
+		script, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.up = string(script)
+		} else {
+			migration.down = string(script)
+		}
+	}
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("migration %d_%s should have both up and down scripts", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
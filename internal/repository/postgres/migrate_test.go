@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LoadMigrations(t *testing.T) {
+	type testCase struct {
+		name     string
+		files    []string
+		versions []uint64
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name: "sorted by version",
+			files: []string{
+				"0010_tags.up.sql", "0010_tags.down.sql",
+				"0002_logs.up.sql", "0002_logs.down.sql",
+				"0001_init.up.sql", "0001_init.down.sql",
+			},
+			versions: []uint64{1, 2, 10},
+		},
+		{
+			name:     "missing down",
+			files:    []string{"0001_init.up.sql", "0001_init.down.sql", "0002_logs.up.sql"},
+			expected: "migration 2_logs should have both up and down scripts",
+		},
+		{
+			name:     "missing up",
+			files:    []string{"0001_init.down.sql"},
+			expected: "migration 1_init should have both up and down scripts",
+		},
+		{
+			name:     "duplicate version",
+			files:    []string{"0001_init.up.sql", "0001_init.down.sql", "0001_logs.up.sql", "0001_logs.down.sql"},
+			expected: "migrations init and logs have the same version",
+		},
+		{
+			name:     "unexpected file",
+			files:    []string{"0001_init.up.sql", "0001_init.down.sql", "README.md"},
+			expected: "unexpected migration file README.md",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, file := range test.files {
+				fsys["migrations/"+file] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+			migrations, err := loadMigrations(fsys, "migrations")
+			if test.expected != "" {
+				assert.EqualError(t, err, test.expected)
+				return
+			}
+			assert.NoError(t, err)
+			versions := make([]uint64, 0, len(migrations))
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			assert.Equal(t, test.versions, versions)
+		})
+	}
+}
+
+func Test_LoadMigrations_Embedded(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	assert.NoError(t, err)
+	for i, migration := range migrations {
+		assert.Equal(t, uint64(i+1), migration.Version)
+	}
+}
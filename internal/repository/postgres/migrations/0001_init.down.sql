@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS logs;
+DROP TABLE IF EXISTS users_segments_archive;
+DROP TABLE IF EXISTS users_segments;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS segment;
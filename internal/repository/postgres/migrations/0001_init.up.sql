@@ -0,0 +1,95 @@
+CREATE TABLE IF NOT EXISTS segment (
+    slug VARCHAR(32) PRIMARY KEY
+);
+
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS percentage DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS rollout VARCHAR(8) NOT NULL DEFAULT 'random';
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS salt VARCHAR(32);
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS default_ttl VARCHAR(16);
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS owner VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS id BIGSERIAL;
+CREATE UNIQUE INDEX IF NOT EXISTS segment_id_idx ON segment (id);
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS state VARCHAR(16) NOT NULL DEFAULT 'active';
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS users_segments (
+    user_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    slug VARCHAR(32) REFERENCES segment (slug) ON DELETE CASCADE,
+    delete_time TIMESTAMPTZ
+);
+
+ALTER TABLE users_segments ADD COLUMN IF NOT EXISTS assign_time TIMESTAMPTZ;
+ALTER TABLE users_segments ALTER COLUMN assign_time SET DEFAULT NOW();
+ALTER TABLE users_segments ADD COLUMN IF NOT EXISTS start_time TIMESTAMPTZ;
+ALTER TABLE users_segments ADD COLUMN IF NOT EXISTS pending BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- memberships follow the segment when it's renamed
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conname = 'users_segments_slug_fkey' AND confupdtype <> 'c'
+    ) THEN
+        ALTER TABLE users_segments DROP CONSTRAINT users_segments_slug_fkey;
+        ALTER TABLE users_segments ADD CONSTRAINT users_segments_slug_fkey
+            FOREIGN KEY (slug) REFERENCES segment (slug) ON DELETE CASCADE ON UPDATE CASCADE;
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS users_segments_archive (
+    user_id INTEGER NOT NULL,
+    slug VARCHAR(32) NOT NULL,
+    delete_time TIMESTAMPTZ,
+    assign_time TIMESTAMPTZ,
+    start_time TIMESTAMPTZ,
+    pending BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS users_segments_archive_slug_idx ON users_segments_archive (slug);
+
+CREATE TABLE IF NOT EXISTS logs (
+    user_id INTEGER NOT NULL,
+    slug VARCHAR(32) NOT NULL,
+    operation VARCHAR(16) NOT NULL,
+    request_time TIMESTAMPTZ NOT NULL
+);
+
+ALTER TABLE logs ADD COLUMN IF NOT EXISTS id BIGSERIAL;
+ALTER TABLE logs ADD COLUMN IF NOT EXISTS reason VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE logs ADD COLUMN IF NOT EXISTS actor VARCHAR(64) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS logs_request_time_idx ON logs (request_time, id);
+CREATE INDEX IF NOT EXISTS logs_user_id_idx ON logs (user_id, request_time, id);
+CREATE INDEX IF NOT EXISTS logs_slug_idx ON logs (slug, request_time, id);
+ALTER TABLE logs ADD COLUMN IF NOT EXISTS segment_id BIGINT;
+CREATE INDEX IF NOT EXISTS logs_segment_id_idx ON logs (segment_id, request_time, id);
+UPDATE logs l SET segment_id = s.id FROM segment s WHERE l.segment_id IS NULL AND l.slug = s.slug;
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    total BIGINT NOT NULL DEFAULT 0,
+    processed BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    errors JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ
+);
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS namespace VARCHAR(32) NOT NULL DEFAULT '';
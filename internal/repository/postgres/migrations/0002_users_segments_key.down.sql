@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS users_segments_pending_idx;
+DROP INDEX IF EXISTS users_segments_delete_time_idx;
+DROP INDEX IF EXISTS users_segments_slug_idx;
+ALTER TABLE users_segments DROP CONSTRAINT IF EXISTS users_segments_pkey;
+ALTER TABLE users_segments ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE users_segments ALTER COLUMN slug DROP NOT NULL;
@@ -0,0 +1,9 @@
+-- duplicates could be inserted by concurrent requests before the key existed
+DELETE FROM users_segments a USING users_segments b
+WHERE a.user_id = b.user_id AND a.slug = b.slug AND a.ctid < b.ctid;
+DELETE FROM users_segments WHERE user_id IS NULL OR slug IS NULL;
+
+ALTER TABLE users_segments ADD CONSTRAINT users_segments_pkey PRIMARY KEY (user_id, slug);
+CREATE INDEX users_segments_slug_idx ON users_segments (slug);
+CREATE INDEX users_segments_delete_time_idx ON users_segments (delete_time) WHERE delete_time IS NOT NULL;
+CREATE INDEX users_segments_pending_idx ON users_segments (start_time) WHERE pending;
@@ -0,0 +1,11 @@
+CREATE TABLE idempotency_keys (
+    actor VARCHAR(64) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    fingerprint CHAR(64) NOT NULL,
+    status INTEGER,
+    content_type TEXT NOT NULL DEFAULT '',
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (actor, key)
+);
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
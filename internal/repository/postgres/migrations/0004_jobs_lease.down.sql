@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS jobs_unfinished_idx;
+ALTER TABLE jobs DROP COLUMN IF EXISTS heartbeat_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS owner;
@@ -0,0 +1,3 @@
+ALTER TABLE jobs ADD COLUMN owner VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+CREATE INDEX jobs_unfinished_idx ON jobs (heartbeat_at) WHERE status IN ('pending', 'running');
@@ -0,0 +1,20 @@
+ALTER TABLE users_segments_archive ADD COLUMN slug VARCHAR(32);
+UPDATE users_segments_archive a SET slug = s.slug FROM segment s WHERE s.id = a.segment_id;
+ALTER TABLE users_segments_archive DROP COLUMN segment_id;
+ALTER TABLE users_segments_archive ALTER COLUMN slug SET NOT NULL;
+CREATE INDEX users_segments_archive_slug_idx ON users_segments_archive (slug);
+
+ALTER TABLE users_segments ADD COLUMN slug VARCHAR(32);
+UPDATE users_segments us SET slug = s.slug FROM segment s WHERE s.id = us.segment_id;
+ALTER TABLE users_segments DROP COLUMN segment_id;
+ALTER TABLE users_segments ALTER COLUMN slug SET NOT NULL;
+
+ALTER TABLE segment DROP CONSTRAINT segment_slug_key;
+ALTER TABLE segment DROP CONSTRAINT segment_pkey;
+ALTER TABLE segment ADD CONSTRAINT segment_pkey PRIMARY KEY (slug);
+CREATE UNIQUE INDEX segment_id_idx ON segment (id);
+
+ALTER TABLE users_segments ADD CONSTRAINT users_segments_slug_fkey
+    FOREIGN KEY (slug) REFERENCES segment (slug) ON DELETE CASCADE ON UPDATE CASCADE;
+ALTER TABLE users_segments ADD CONSTRAINT users_segments_pkey PRIMARY KEY (user_id, slug);
+CREATE INDEX users_segments_slug_idx ON users_segments (slug);
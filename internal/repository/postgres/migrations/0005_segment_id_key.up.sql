@@ -0,0 +1,31 @@
+-- memberships refer to segments by id, so renaming a segment changes only its own row
+ALTER TABLE users_segments ADD COLUMN segment_id BIGINT;
+UPDATE users_segments us SET segment_id = s.id FROM segment s WHERE s.slug = us.slug;
+ALTER TABLE users_segments DROP CONSTRAINT users_segments_pkey;
+DROP INDEX IF EXISTS users_segments_slug_idx;
+ALTER TABLE users_segments DROP COLUMN slug;
+ALTER TABLE users_segments ALTER COLUMN segment_id SET NOT NULL;
+
+ALTER TABLE users_segments_archive ADD COLUMN segment_id BIGINT;
+UPDATE users_segments_archive a SET segment_id = s.id FROM segment s WHERE s.slug = a.slug;
+DELETE FROM users_segments_archive WHERE segment_id IS NULL;
+DROP INDEX IF EXISTS users_segments_archive_slug_idx;
+ALTER TABLE users_segments_archive DROP COLUMN slug;
+ALTER TABLE users_segments_archive ALTER COLUMN segment_id SET NOT NULL;
+
+ALTER TABLE segment DROP CONSTRAINT segment_pkey;
+DROP INDEX IF EXISTS segment_id_idx;
+ALTER TABLE segment ADD CONSTRAINT segment_pkey PRIMARY KEY (id);
+ALTER TABLE segment ADD CONSTRAINT segment_slug_key UNIQUE (slug);
+
+ALTER TABLE users_segments ADD CONSTRAINT users_segments_pkey PRIMARY KEY (user_id, segment_id);
+ALTER TABLE users_segments ADD CONSTRAINT users_segments_segment_id_fkey
+    FOREIGN KEY (segment_id) REFERENCES segment (id) ON DELETE CASCADE;
+CREATE INDEX users_segments_segment_id_idx ON users_segments (segment_id);
+
+ALTER TABLE users_segments_archive ADD CONSTRAINT users_segments_archive_segment_id_fkey
+    FOREIGN KEY (segment_id) REFERENCES segment (id) ON DELETE CASCADE;
+CREATE INDEX users_segments_archive_segment_id_idx ON users_segments_archive (segment_id);
+
+-- logs outlive purged segments, so segment_id isn't a foreign key
+UPDATE logs l SET segment_id = s.id FROM segment s WHERE l.segment_id IS NULL AND l.slug = s.slug;
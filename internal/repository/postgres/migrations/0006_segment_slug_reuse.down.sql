@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS segment_deleted_slug_idx;
+DROP INDEX IF EXISTS segment_slug_key;
+-- deleted segments whose slug has been taken again can't be kept
+DELETE FROM segment s WHERE s.deleted_at IS NOT NULL AND EXISTS (
+    SELECT 1 FROM segment o WHERE o.slug = s.slug AND o.id <> s.id
+    AND (o.deleted_at IS NULL OR o.deleted_at > s.deleted_at)
+);
+ALTER TABLE segment ADD CONSTRAINT segment_slug_key UNIQUE (slug);
@@ -0,0 +1,5 @@
+-- slug of a deleted segment can be taken by a new one while the deleted one
+-- waits to be purged
+ALTER TABLE segment DROP CONSTRAINT segment_slug_key;
+CREATE UNIQUE INDEX segment_slug_key ON segment (slug) WHERE deleted_at IS NULL;
+CREATE INDEX segment_deleted_slug_idx ON segment (slug, deleted_at) WHERE deleted_at IS NOT NULL;
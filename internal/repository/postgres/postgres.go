@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/kiryu-dev/segments-api/internal/config"
@@ -21,17 +20,5 @@ func New(cfg *config.DB) (*sql.DB, error) {
 	if err := db.PingContext(ctx); err != nil {
 		return nil, fmt.Errorf("cannot get access to postgtes: %w", err)
 	}
-	if err := initDB(ctx, db, cfg.InitFilepath); err != nil {
-		return nil, fmt.Errorf("cannot initialize tables: %w", err)
-	}
 	return db, nil
 }
-
-func initDB(ctx context.Context, db *sql.DB, initFilepath string) error {
-	buf, err := os.ReadFile(initFilepath)
-	if err != nil {
-		return err
-	}
-	_, err = db.ExecContext(ctx, string(buf))
-	return err
-}
@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/kiryu-dev/segments-api/internal/repository"
+)
+
+type Transactor struct {
+	db *sql.DB
+}
+
+func NewTransactor(db *sql.DB) *Transactor {
+	return &Transactor{db}
+}
+
+// WithinTx runs fn in a single transaction: it's committed if fn returns nil
+// and rolled back otherwise.
+func (t *Transactor) WithinTx(ctx context.Context, fn func(context.Context) error) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %v", err)
+	}
+	if err := fn(repository.WithTx(ctx, tx)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit transaction: %v", err)
+	}
+	return nil
+}
@@ -3,13 +3,27 @@ package segment
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/kiryu-dev/segments-api/internal/model"
 	"github.com/kiryu-dev/segments-api/internal/repository"
-	"github.com/kiryu-dev/segments-api/pkg/util/parser"
+	"github.com/lib/pq"
 )
 
+const uniqueViolation = "23505"
+
+// statsQuery counts only members whose scheduled segment has started, the
+// same way segments of a user are listed.
+const statsQuery = `
+SELECT s.id, s.slug, s.description, s.tags, s.state, s.percentage, s.rollout, COALESCE(s.default_ttl, ''), s.owner,
+s.created_at, COUNT(us.user_id),
+COUNT(us.delete_time), MIN(us.delete_time), MAX(us.delete_time)
+FROM segment s LEFT JOIN users_segments us ON us.segment_id = s.id
+AND (us.start_time IS NULL OR us.start_time <= NOW())
+`
+
 type repo struct {
 	db *sql.DB
 }
@@ -18,19 +32,191 @@ func New(db *sql.DB) *repo {
 	return &repo{db}
 }
 
-func (r *repo) Create(ctx context.Context, slug string) error {
-	query := `INSERT INTO segment (slug) VALUES ($1);`
-	if _, err := r.db.ExecContext(ctx, query, slug); err != nil {
+// Create stores the segment and sets its id.
+func (r *repo) Create(ctx context.Context, seg *model.Segment) error {
+	query := `
+INSERT INTO segment (slug, percentage, rollout, salt, default_ttl, owner, description, tags, state)
+VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, COALESCE($8, '{}'), $9)
+RETURNING id;
+	`
+	err := repository.Conn(ctx, r.db, "segment", "Create").QueryRowContext(ctx, query, seg.Slug, seg.Percentage, seg.Rollout,
+		seg.Salt, seg.DefaultTTL, seg.Owner, seg.Description, pq.StringArray(seg.Tags), seg.State).Scan(&seg.ID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
 		return repository.ErrSegmentExists
 	}
+	if err != nil {
+		return fmt.Errorf("error creating segment %s: %v", seg.Slug, err)
+	}
 	return nil
 }
 
-func (r *repo) Delete(ctx context.Context, slug string) error {
-	query := `DELETE FROM segment WHERE slug = $1;`
-	res, err := r.db.ExecContext(ctx, query, slug)
+// Resolve returns ids, owners, states and rollout rules of existing segments
+// among slugs by their slugs. A slug may be held by deleted segments which
+// may still be restored, the segment which isn't deleted is preferred and
+// the last deleted one otherwise.
+func (r *repo) Resolve(ctx context.Context, slugs []string) (map[string]*model.Segment, error) {
+	var (
+		query = `
+SELECT DISTINCT ON (slug) id, slug, owner, state, percentage, rollout, COALESCE(salt, '') FROM segment
+WHERE slug = ANY($1) ORDER BY slug, deleted_at DESC NULLS FIRST;
+		`
+		segments = make(map[string]*model.Segment, len(slugs))
+	)
+	rows, err := repository.Conn(ctx, r.db, "segment", "Resolve").QueryContext(ctx, query, pq.StringArray(slugs))
+	if err != nil {
+		return nil, fmt.Errorf("error resolving segments: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		seg := new(model.Segment)
+		if err := rows.Scan(&seg.ID, &seg.Slug, &seg.Owner, &seg.State, &seg.Percentage,
+			&seg.Rollout, &seg.Salt); err != nil {
+			return nil, fmt.Errorf("error resolving segments: %v", err)
+		}
+		segments[seg.Slug] = seg
+	}
+	return segments, rows.Err()
+}
+
+// CountMembers returns the number of members of every segment which isn't
+// deleted. Users whose scheduled segment hasn't started yet aren't counted.
+func (r *repo) CountMembers(ctx context.Context) (map[string]uint64, error) {
+	query := `
+SELECT s.slug, COUNT(us.user_id) FROM segment s LEFT JOIN users_segments us ON us.segment_id = s.id
+AND (us.start_time IS NULL OR us.start_time <= NOW())
+WHERE s.deleted_at IS NULL GROUP BY s.id;
+	`
+	rows, err := repository.Conn(ctx, r.db, "segment", "CountMembers").QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error counting members of segments: %v", err)
+	}
+	defer rows.Close()
+	members := make(map[string]uint64)
+	for rows.Next() {
+		var (
+			slug  string
+			count uint64
+		)
+		if err := rows.Scan(&slug, &count); err != nil {
+			return nil, fmt.Errorf("error counting members of segments: %v", err)
+		}
+		members[slug] = count
+	}
+	return members, rows.Err()
+}
+
+func (r *repo) GetRollouts(ctx context.Context) ([]*model.Segment, error) {
+	var (
+		query = `
+SELECT id, slug, percentage, rollout, COALESCE(salt, '') FROM segment
+WHERE percentage > 0 AND deleted_at IS NULL AND state = 'active';
+		`
+		segments = make([]*model.Segment, 0)
+	)
+	rows, err := repository.Conn(ctx, r.db, "segment", "GetRollouts").QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error getting rollout segments: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		seg := new(model.Segment)
+		if err := rows.Scan(&seg.ID, &seg.Slug, &seg.Percentage, &seg.Rollout, &seg.Salt); err != nil {
+			return nil, fmt.Errorf("error getting rollout segments: %v", err)
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+func (r *repo) Get(ctx context.Context, slug string) (*model.SegmentStats, error) {
+	query := statsQuery + `WHERE s.slug = $1 AND s.deleted_at IS NULL GROUP BY s.id;`
+	stats, err := scanStats(repository.Conn(ctx, r.db, "segment", "Get").QueryRowContext(ctx, query, slug))
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrSegmentNotExists
+	}
 	if err != nil {
-		return fmt.Errorf("error deleting segment with name %s: %v", slug, err)
+		return nil, fmt.Errorf("error getting segment %s: %v", slug, err)
+	}
+	return stats, nil
+}
+
+// List returns at most limit segments which names start with prefix and
+// go after cursor in alphabetical order.
+func (r *repo) List(ctx context.Context, prefix, cursor string, limit int) ([]*model.SegmentStats, error) {
+	var (
+		query = statsQuery + `
+WHERE starts_with(s.slug, $1) AND s.slug > $2 AND s.deleted_at IS NULL
+GROUP BY s.id ORDER BY s.slug LIMIT $3;
+		`
+		segments = make([]*model.SegmentStats, 0)
+	)
+	rows, err := repository.Conn(ctx, r.db, "segment", "List").QueryContext(ctx, query, prefix, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting segments: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		stats, err := scanStats(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error getting segments: %v", err)
+		}
+		segments = append(segments, stats)
+	}
+	return segments, nil
+}
+
+// GetMembers returns a page of the segment's members ordered by user id.
+func (r *repo) GetMembers(ctx context.Context, slug string, filter *model.MembersFilter) ([]*model.UserSegment, error) {
+	var (
+		query = `
+SELECT us.user_id, us.segment_id, s.slug, us.delete_time, us.assign_time
+FROM users_segments us JOIN segment s ON s.id = us.segment_id
+WHERE s.slug = $1 AND s.deleted_at IS NULL AND us.user_id > $2
+AND (us.start_time IS NULL OR us.start_time <= NOW())
+AND ($3::BOOLEAN IS NULL OR (us.delete_time IS NOT NULL) = $3)
+AND ($4::TIMESTAMPTZ IS NULL OR us.delete_time < $4)
+ORDER BY us.user_id LIMIT $5;
+		`
+		members = make([]*model.UserSegment, 0)
+	)
+	rows, err := repository.Conn(ctx, r.db, "segment", "GetMembers").QueryContext(ctx, query, slug, filter.Cursor,
+		filter.HasTTL, filter.ExpiresBefore, filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting members of segment %s: %v", slug, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		member := new(model.UserSegment)
+		err := rows.Scan(&member.UserID, &member.SegmentID, &member.Slug, &member.DeleteTime, &member.AssignTime)
+		if err != nil {
+			return nil, fmt.Errorf("error getting members of segment %s: %v", slug, err)
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Update changes the specified fields of the segment. Memberships and logs
+// refer to the segment by id, so renaming it changes only the segment's row.
+func (r *repo) Update(ctx context.Context, id uint64, upd *model.SegmentUpdate) error {
+	query := `
+UPDATE segment SET slug = COALESCE($2, slug), description = COALESCE($3, description),
+tags = COALESCE($4, tags), state = COALESCE($5, state), owner = COALESCE($6, owner)
+WHERE id = $1 AND deleted_at IS NULL;
+	`
+	var tags any
+	if upd.Tags != nil {
+		tags = pq.StringArray(*upd.Tags)
+	}
+	res, err := repository.Conn(ctx, r.db, "segment", "Update").ExecContext(ctx, query,
+		id, upd.Slug, upd.Description, tags, upd.State, upd.Owner)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return repository.ErrSegmentExists
+	}
+	if err != nil {
+		return fmt.Errorf("error updating segment %d: %v", id, err)
 	}
 	if count, _ := res.RowsAffected(); count == 0 {
 		return repository.ErrSegmentNotExists
@@ -38,45 +224,221 @@ func (r *repo) Delete(ctx context.Context, slug string) error {
 	return nil
 }
 
-func (r *repo) DeleteByTTL(ctx context.Context) ([]*model.UserSegment, error) {
+// SetState moves the segment from one state to another. It reports whether
+// the segment has been moved, which fails if it's not in the from state, so
+// only one of concurrent transitions takes place.
+func (r *repo) SetState(ctx context.Context, id uint64, from, to model.SegmentState) (bool, error) {
+	query := `UPDATE segment SET state = $3 WHERE id = $1 AND state = $2 AND deleted_at IS NULL;`
+	res, err := repository.Conn(ctx, r.db, "segment", "SetState").ExecContext(ctx, query, id, from, to)
+	if err != nil {
+		return false, fmt.Errorf("error changing state of segment %d: %v", id, err)
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+// Delete marks the segment as deleted and moves its memberships to the
+// archive, so the segment can be restored until it's purged.
+func (r *repo) Delete(ctx context.Context, id uint64) error {
+	var (
+		conn  = repository.Conn(ctx, r.db, "segment", "Delete")
+		query = `UPDATE segment SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL;`
+	)
+	res, err := conn.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("error deleting segment %d: %v", id, err)
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return repository.ErrSegmentNotExists
+	}
+	query = `
+WITH archived AS (
+	DELETE FROM users_segments WHERE segment_id = $1
+	RETURNING user_id, segment_id, delete_time, assign_time, start_time, pending
+)
+INSERT INTO users_segments_archive (user_id, segment_id, delete_time, assign_time, start_time, pending)
+SELECT * FROM archived;
+	`
+	if _, err := conn.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("error archiving users of segment %d: %v", id, err)
+	}
+	return nil
+}
+
+// Discard deletes the segment with its memberships for good. It undoes the
+// creation of a segment whose setup has failed.
+func (r *repo) Discard(ctx context.Context, id uint64) error {
+	query := `DELETE FROM segment WHERE id = $1;`
+	if _, err := repository.Conn(ctx, r.db, "segment", "Discard").ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("error discarding segment %d: %v", id, err)
+	}
+	return nil
+}
+
+// Restore brings back the deleted segment with memberships which haven't
+// expired while it was deleted and whose users still exist. It returns ids
+// of users whose segment is active again, scheduled ones are left out.
+// GetDeleted returns id and owner of the last deleted segment with the slug.
+func (r *repo) GetDeleted(ctx context.Context, slug string) (*model.Segment, error) {
+	query := `
+SELECT id, slug, owner FROM segment WHERE slug = $1 AND deleted_at IS NOT NULL
+ORDER BY deleted_at DESC LIMIT 1;
+	`
+	seg := new(model.Segment)
+	err := repository.Conn(ctx, r.db, "segment", "GetDeleted").QueryRowContext(ctx, query, slug).
+		Scan(&seg.ID, &seg.Slug, &seg.Owner)
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotDeleted
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting deleted segment %s: %v", slug, err)
+	}
+	return seg, nil
+}
+
+func (r *repo) Restore(ctx context.Context, id uint64) ([]uint64, error) {
+	var (
+		conn  = repository.Conn(ctx, r.db, "segment", "Restore")
+		query = `UPDATE segment SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL;`
+	)
+	res, err := conn.ExecContext(ctx, query, id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		// the slug has been taken by another segment since the deletion
+		return nil, repository.ErrSlugTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error restoring segment %d: %v", id, err)
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return nil, repository.ErrNotDeleted
+	}
+	query = `
+WITH restored AS (
+	DELETE FROM users_segments_archive WHERE segment_id = $1
+	RETURNING user_id, segment_id, delete_time, assign_time, start_time, pending
+)
+INSERT INTO users_segments (user_id, segment_id, delete_time, assign_time, start_time, pending)
+SELECT r.* FROM restored r JOIN users u ON u.id = r.user_id
+WHERE r.delete_time IS NULL OR r.delete_time > NOW()
+ON CONFLICT (user_id, segment_id) DO NOTHING
+RETURNING user_id, pending;
+	`
+	rows, err := conn.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("error restoring users of segment %d: %v", id, err)
+	}
+	defer rows.Close()
+	users := make([]uint64, 0)
+	for rows.Next() {
+		var (
+			id      uint64
+			pending bool
+		)
+		if err := rows.Scan(&id, &pending); err != nil {
+			return nil, fmt.Errorf("error restoring users of segment %d: %v", id, err)
+		}
+		if !pending {
+			users = append(users, id)
+		}
+	}
+	return users, nil
+}
+
+// Purge permanently deletes segments deleted before the time with their
+// archived memberships, which are deleted by the foreign key. It returns the
+// number of purged segments.
+func (r *repo) Purge(ctx context.Context, before time.Time) (int, error) {
 	var (
 		query = `
-DELETE FROM users_segments WHERE delete_time < NOW()
-RETURNING (user_id, slug);
+WITH purged AS (
+	DELETE FROM segment WHERE deleted_at < $1 RETURNING id
+)
+SELECT COUNT(*) FROM purged;
+		`
+		count int
+	)
+	err := repository.Conn(ctx, r.db, "segment", "Purge").QueryRowContext(ctx, query, before).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error purging deleted segments: %v", err)
+	}
+	return count, nil
+}
+
+// DeleteByTTL deletes at most limit time expired segments of users.
+func (r *repo) DeleteByTTL(ctx context.Context, limit int) ([]*model.UserSegment, error) {
+	var (
+		query = `
+WITH deleted AS (
+	DELETE FROM users_segments WHERE ctid IN (
+		SELECT ctid FROM users_segments WHERE delete_time < NOW()
+		LIMIT $1 FOR UPDATE SKIP LOCKED
+	)
+	RETURNING user_id, segment_id
+)
+SELECT d.user_id, d.segment_id, s.slug FROM deleted d JOIN segment s ON s.id = d.segment_id;
 		`
 		segments = make([]*model.UserSegment, 0)
 	)
-	rows, err := r.db.QueryContext(ctx, query)
+	rows, err := repository.Conn(ctx, r.db, "segment", "DeleteByTTL").QueryContext(ctx, query, limit)
 	if err != nil {
 		return nil, fmt.Errorf("error deleting time expired segments: %v", err)
 	}
+	defer rows.Close()
 	for rows.Next() {
-		buf := make([]byte, 0)
-		if err := rows.Scan(&buf); err != nil {
+		seg := new(model.UserSegment)
+		if err := rows.Scan(&seg.UserID, &seg.SegmentID, &seg.Slug); err != nil {
 			return nil, fmt.Errorf("error getting deleted users' segments: %v", err)
 		}
-		id, slug := parser.ParseResponse(buf)
-		segments = append(segments, &model.UserSegment{
-			UserID: id,
-			Slug:   slug,
-		})
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// ActivateScheduled marks at most limit segments of users which start time
+// has come as active and returns them.
+func (r *repo) ActivateScheduled(ctx context.Context, limit int) ([]*model.UserSegment, error) {
+	var (
+		query = `
+UPDATE users_segments us SET pending = FALSE FROM segment s
+WHERE s.id = us.segment_id AND us.ctid IN (
+	SELECT ctid FROM users_segments WHERE pending AND start_time <= NOW()
+	LIMIT $1 FOR UPDATE SKIP LOCKED
+)
+RETURNING us.user_id, us.segment_id, s.slug, us.start_time;
+		`
+		segments = make([]*model.UserSegment, 0)
+	)
+	rows, err := repository.Conn(ctx, r.db, "segment", "ActivateScheduled").QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error activating scheduled segments: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		seg := new(model.UserSegment)
+		if err := rows.Scan(&seg.UserID, &seg.SegmentID, &seg.Slug, &seg.StartTime); err != nil {
+			return nil, fmt.Errorf("error getting activated users' segments: %v", err)
+		}
+		segments = append(segments, seg)
 	}
 	return segments, nil
 }
 
-func (r *repo) GetUsersBySegment(ctx context.Context, slug string) ([]uint64, error) {
+// GetUsersBySegment returns ids of users whose segment has been logged as
+// added, scheduled ones which haven't been activated yet are left out.
+func (r *repo) GetUsersBySegment(ctx context.Context, id uint64) ([]uint64, error) {
 	var (
-		query = `SELECT user_id FROM users_segments WHERE slug = $1;`
+		query = `SELECT user_id FROM users_segments WHERE segment_id = $1 AND NOT pending;`
 		users = make([]uint64, 0)
 	)
-	rows, err := r.db.QueryContext(ctx, query, slug)
+	rows, err := repository.Conn(ctx, r.db, "segment", "GetUsersBySegment").QueryContext(ctx, query, id)
 	if err != nil {
-		return nil, fmt.Errorf("error getting users by specified segment %s: %v", slug, err)
+		return nil, fmt.Errorf("error getting users by specified segment %d: %v", id, err)
 	}
 	for rows.Next() {
 		var id uint64
 		if err := rows.Scan(&id); err != nil {
-			return nil, fmt.Errorf("error getting users by specified segment %s: %v", slug, err)
+			return nil, fmt.Errorf("error getting users by specified segment %d: %v", id, err)
 		}
 		users = append(users, id)
 	}
@@ -85,3 +447,17 @@ func (r *repo) GetUsersBySegment(ctx context.Context, slug string) ([]uint64, er
 	}
 	return users, nil
 }
+
+type scanner interface {
+	Scan(...any) error
+}
+
+func scanStats(row scanner) (*model.SegmentStats, error) {
+	stats := new(model.SegmentStats)
+	err := row.Scan(&stats.ID, &stats.Slug, &stats.Description, (*pq.StringArray)(&stats.Tags), &stats.State,
+		&stats.Percentage, &stats.Rollout, &stats.DefaultTTL, &stats.Owner, &stats.CreatedAt, &stats.Members, &stats.MembersWithTTL, &stats.NextExpiry, &stats.LastExpiry)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
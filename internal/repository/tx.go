@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Executor interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+// Observer is called before every statement executed through Conn with the
+// repository and method the statement belongs to. The returned function is
+// called with the error of the statement when it's done.
+type Observer func(ctx context.Context, repo, method, query string) (context.Context, func(error))
+
+var observer Observer
+
+// Observe sets the observer of statements, e.g. to record their latency and
+// spans. It's meant to be called once on startup.
+func Observe(o Observer) {
+	observer = o
+}
+
+type txKey struct{}
+
+func WithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// Conn returns the transaction bound to ctx or db if there's no one,
+// so repositories can take part in a transaction opened by a service.
+// Statements are observed as made by the method of the repository.
+func Conn(ctx context.Context, db *sql.DB, repo, method string) Executor {
+	var exec Executor = db
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		exec = tx
+	}
+	if observer == nil {
+		return exec
+	}
+	return &observedExecutor{exec, repo, method}
+}
+
+type observedExecutor struct {
+	exec   Executor
+	repo   string
+	method string
+}
+
+func (e *observedExecutor) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, done := observer(ctx, e.repo, e.method, query)
+	res, err := e.exec.ExecContext(ctx, query, args...)
+	done(err)
+	return res, err
+}
+
+func (e *observedExecutor) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, done := observer(ctx, e.repo, e.method, query)
+	rows, err := e.exec.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+func (e *observedExecutor) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, done := observer(ctx, e.repo, e.method, query)
+	row := e.exec.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
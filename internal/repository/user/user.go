@@ -3,12 +3,29 @@ package user
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/kiryu-dev/segments-api/internal/model"
 	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/lib/pq"
 )
 
+// lockedSegment selects the segment with id $2 locking it until the end of
+// the transaction, so that it can't be deleted or archived while members are
+// added to it. When the segment is changed concurrently, the lock waits for
+// the change and the row is selected as it's been changed. default_ttl is
+// applied to segments added without explicit delete time.
+const lockedSegment = `
+locked AS (
+	SELECT id, deleted_at IS NULL AS present, state, ('P' || UPPER(default_ttl))::INTERVAL AS default_ttl
+	FROM segment WHERE id = $2 FOR SHARE
+)
+`
+
+const uniqueViolation = "23505"
+
 type repo struct {
 	db *sql.DB
 }
@@ -19,15 +36,20 @@ func New(db *sql.DB) *repo {
 
 func (r *repo) Create(ctx context.Context, userID uint64) error {
 	query := `INSERT INTO users (id) VALUES ($1);`
-	if _, err := r.db.ExecContext(ctx, query, userID); err != nil {
+	_, err := repository.Conn(ctx, r.db, "user", "Create").ExecContext(ctx, query, userID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
 		return repository.ErrUserExists
 	}
+	if err != nil {
+		return fmt.Errorf("error creating user with ID %d: %v", userID, err)
+	}
 	return nil
 }
 
 func (r *repo) Delete(ctx context.Context, userID uint64) error {
 	query := `DELETE FROM users WHERE id = $1;`
-	res, err := r.db.ExecContext(ctx, query, userID)
+	res, err := repository.Conn(ctx, r.db, "user", "Delete").ExecContext(ctx, query, userID)
 	if err != nil {
 		return fmt.Errorf("error deleting user with ID %d: %v", userID, err)
 	}
@@ -39,10 +61,13 @@ func (r *repo) Delete(ctx context.Context, userID uint64) error {
 
 func (r *repo) GetUserSegments(ctx context.Context, userID uint64) ([]string, error) {
 	var (
-		query    = `SELECT slug FROM users_segments WHERE user_id = $1;`
+		query = `
+SELECT s.slug FROM users_segments us JOIN segment s ON s.id = us.segment_id
+WHERE us.user_id = $1 AND (us.start_time IS NULL OR us.start_time <= NOW());
+		`
 		segments = make([]string, 0)
 	)
-	rows, err := r.db.QueryContext(ctx, query, userID)
+	rows, err := repository.Conn(ctx, r.db, "user", "GetUserSegments").QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("error getting segments of user with ID %d: %v", userID, err)
 	}
@@ -59,38 +84,192 @@ func (r *repo) GetUserSegments(ctx context.Context, userID uint64) ([]string, er
 	return segments, nil
 }
 
+func (r *repo) GetUserSegmentsDetailed(ctx context.Context, userID uint64) ([]*model.UserSegment, error) {
+	var (
+		query = `
+SELECT us.user_id, us.segment_id, s.slug, us.delete_time, us.assign_time, us.start_time
+FROM users_segments us JOIN segment s ON s.id = us.segment_id
+WHERE us.user_id = $1 AND (us.start_time IS NULL OR us.start_time <= NOW()) ORDER BY s.slug;
+		`
+		segments = make([]*model.UserSegment, 0)
+	)
+	rows, err := repository.Conn(ctx, r.db, "user", "GetUserSegmentsDetailed").QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting segments of user with ID %d: %v", userID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		seg := new(model.UserSegment)
+		err := rows.Scan(&seg.UserID, &seg.SegmentID, &seg.Slug, &seg.DeleteTime, &seg.AssignTime, &seg.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("error getting segments of user with ID %d: %v", userID, err)
+		}
+		segments = append(segments, seg)
+	}
+	if len(segments) == 0 {
+		return nil, repository.ErrUserNotExists
+	}
+	return segments, nil
+}
+
+// AddSegment adds the segment referred by SegmentID to the user. It returns
+// ErrHasSegment if the user already has it, with ConflictRefresh policy
+// delete time of the segment is replaced anyway.
 func (r *repo) AddSegment(ctx context.Context, seg *model.UserSegment) error {
+	onConflict := "NOTHING"
+	if seg.OnConflict == model.ConflictRefresh {
+		onConflict = "UPDATE SET delete_time = EXCLUDED.delete_time"
+	}
+	// xmax of a row is zero unless it's been updated by ON CONFLICT
 	query := `
-INSERT INTO users_segments (user_id, slug, delete_time)
-VALUES ($1, $2, $3);
+WITH ` + lockedSegment + `, added AS (
+	INSERT INTO users_segments (user_id, segment_id, delete_time, start_time, pending)
+	SELECT $1::INTEGER, id, COALESCE($3::TIMESTAMPTZ, COALESCE($4::TIMESTAMPTZ, NOW()) + default_ttl),
+	$4::TIMESTAMPTZ, COALESCE($4::TIMESTAMPTZ > NOW(), FALSE)
+	FROM locked WHERE present AND state <> 'archived'
+	ON CONFLICT (user_id, segment_id) DO ` + onConflict + `
+	RETURNING xmax = 0 AS inserted
+)
+SELECT present, state, (SELECT inserted FROM added) FROM locked;
 	`
-	err := r.findDublicate(ctx, seg.UserID, seg.Slug)
-	if err != sql.ErrNoRows {
+	var (
+		present  bool
+		state    model.SegmentState
+		inserted sql.NullBool
+	)
+	err := repository.Conn(ctx, r.db, "user", "AddSegment").QueryRowContext(ctx, query, seg.UserID, seg.SegmentID,
+		seg.DeleteTime, seg.StartTime).Scan(&present, &state, &inserted)
+	if err == sql.ErrNoRows {
+		return repository.ErrSegmentNotExists
+	}
+	if err != nil {
+		return fmt.Errorf("error adding segment %s to user with ID %d: %v", seg.Slug, seg.UserID, err)
+	}
+	if err := checkSegment(present, state); err != nil {
+		return err
+	}
+	if !inserted.Bool {
 		return repository.ErrHasSegment
 	}
-	_, err = r.db.ExecContext(ctx, query, seg.UserID, seg.Slug, seg.DeleteTime)
-	return err
+	return nil
 }
 
-func (r *repo) DeleteSegment(ctx context.Context, seg *model.UserSegment) error {
-	query := `DELETE FROM users_segments WHERE user_id = $1 AND slug = $2;`
-	res, err := r.db.ExecContext(ctx, query, seg.UserID, seg.Slug)
+// SetDeleteTime replaces delete time of the user's segment, nil means the
+// segment never expires.
+func (r *repo) SetDeleteTime(ctx context.Context, seg *model.UserSegment) error {
+	query := `
+UPDATE users_segments SET delete_time = $3 WHERE user_id = $1 AND segment_id = $2
+RETURNING assign_time;
+	`
+	err := repository.Conn(ctx, r.db, "user", "SetDeleteTime").QueryRowContext(ctx, query, seg.UserID, seg.SegmentID,
+		seg.DeleteTime).Scan(&seg.AssignTime)
+	if err == sql.ErrNoRows {
+		return repository.ErrNoSegment
+	}
 	if err != nil {
-		return fmt.Errorf("error deleting segment %s to user with ID %d: %v",
+		return fmt.Errorf("error updating ttl of segment %s of user with ID %d: %v",
 			seg.Slug, seg.UserID, err)
 	}
-	if count, _ := res.RowsAffected(); count == 0 {
-		return repository.ErrSegmentNotExists
+	return nil
+}
+
+// ExtendDeleteTime prolongs the user's segment for ttl counting from its
+// current delete time or from now if it never expires.
+func (r *repo) ExtendDeleteTime(ctx context.Context, seg *model.UserSegment, ttl string) error {
+	query := `
+UPDATE users_segments SET delete_time = COALESCE(delete_time, NOW()) + ('P' || UPPER($3))::INTERVAL
+WHERE user_id = $1 AND segment_id = $2
+RETURNING delete_time, assign_time;
+	`
+	err := repository.Conn(ctx, r.db, "user", "ExtendDeleteTime").QueryRowContext(ctx, query, seg.UserID, seg.SegmentID,
+		ttl).Scan(&seg.DeleteTime, &seg.AssignTime)
+	if err == sql.ErrNoRows {
+		return repository.ErrNoSegment
+	}
+	if err != nil {
+		return fmt.Errorf("error extending ttl of segment %s of user with ID %d: %v",
+			seg.Slug, seg.UserID, err)
 	}
 	return nil
 }
 
+// DeleteSegment deletes the segment from the user. It reports whether the
+// segment has been active, a scheduled one which hasn't been activated yet
+// was never logged as added.
+func (r *repo) DeleteSegment(ctx context.Context, seg *model.UserSegment) (bool, error) {
+	query := `DELETE FROM users_segments WHERE user_id = $1 AND segment_id = $2 RETURNING NOT pending;`
+	var active bool
+	err := repository.Conn(ctx, r.db, "user", "DeleteSegment").QueryRowContext(ctx, query,
+		seg.UserID, seg.SegmentID).Scan(&active)
+	if err == sql.ErrNoRows {
+		return false, repository.ErrSegmentNotExists
+	}
+	if err != nil {
+		return false, fmt.Errorf("error deleting segment %s to user with ID %d: %v",
+			seg.Slug, seg.UserID, err)
+	}
+	return active, nil
+}
+
+// AddSegmentBulk adds the segment to those of specified users that exist and
+// don't have it yet. It returns ids of users the segment was added to.
+func (r *repo) AddSegmentBulk(ctx context.Context, userIDs []uint64, segmentID uint64,
+	deleteTime *time.Time) ([]uint64, error) {
+	query := `
+WITH ` + lockedSegment + `, added AS (
+	INSERT INTO users_segments (user_id, segment_id, delete_time)
+	SELECT u.id, l.id, COALESCE($3::TIMESTAMPTZ, NOW() + l.default_ttl) FROM users u, locked l
+	WHERE u.id = ANY($1) AND l.present AND l.state <> 'archived'
+	ON CONFLICT (user_id, segment_id) DO NOTHING
+	RETURNING user_id
+)
+SELECT present, state, ARRAY(SELECT user_id FROM added) FROM locked;
+	`
+	var (
+		present bool
+		state   model.SegmentState
+		added   pq.Int64Array
+	)
+	err := repository.Conn(ctx, r.db, "user", "AddSegmentBulk").QueryRowContext(ctx, query, toArray(userIDs), segmentID,
+		deleteTime).Scan(&present, &state, &added)
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrSegmentNotExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error adding segment %d to users: %v", segmentID, err)
+	}
+	if err := checkSegment(present, state); err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, len(added))
+	for i, id := range added {
+		ids[i] = uint64(id)
+	}
+	return ids, nil
+}
+
+// DeleteSegmentBulk deletes the segment from specified users. It returns ids
+// of users that had the segment active, scheduled ones are deleted silently.
+func (r *repo) DeleteSegmentBulk(ctx context.Context, userIDs []uint64, segmentID uint64) ([]uint64, error) {
+	query := `
+WITH deleted AS (
+	DELETE FROM users_segments WHERE user_id = ANY($1) AND segment_id = $2 RETURNING user_id, pending
+)
+SELECT user_id FROM deleted WHERE NOT pending;
+	`
+	rows, err := repository.Conn(ctx, r.db, "user", "DeleteSegmentBulk").QueryContext(ctx, query, toArray(userIDs), segmentID)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting segment %d from users: %v", segmentID, err)
+	}
+	return scanIDs(rows)
+}
+
 func (r *repo) GetAll(ctx context.Context) ([]uint64, error) {
 	var (
 		query = `SELECT id FROM users;`
 		users = make([]uint64, 0)
 	)
-	rows, err := r.db.QueryContext(ctx, query)
+	rows, err := repository.Conn(ctx, r.db, "user", "GetAll").QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("error getting users: %v", err)
 	}
@@ -104,7 +283,35 @@ func (r *repo) GetAll(ctx context.Context) ([]uint64, error) {
 	return users, nil
 }
 
-func (r *repo) findDublicate(ctx context.Context, userID uint64, slug string) error {
-	query := `SELECT user_id FROM users_segments WHERE user_id = $1 AND slug = $2;`
-	return r.db.QueryRowContext(ctx, query, userID, slug).Scan()
+// checkSegment returns ErrSegmentNotExists if the segment is deleted and
+// ErrSegmentArchived if it's archived, so it can't be added to users.
+func checkSegment(present bool, state model.SegmentState) error {
+	if !present {
+		return repository.ErrSegmentNotExists
+	}
+	if state == model.ArchivedState {
+		return repository.ErrSegmentArchived
+	}
+	return nil
+}
+
+func toArray(ids []uint64) any {
+	result := make(pq.Int64Array, len(ids))
+	for i, id := range ids {
+		result[i] = int64(id)
+	}
+	return result
+}
+
+func scanIDs(rows *sql.Rows) ([]uint64, error) {
+	defer rows.Close()
+	ids := make([]uint64, 0)
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
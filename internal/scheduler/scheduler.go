@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/metrics"
+	"github.com/kiryu-dev/segments-api/internal/tracing"
+)
+
+type leaderLock interface {
+	TryAcquire(context.Context) (bool, error)
+	Release(context.Context) error
+}
+
+type Task struct {
+	Name string
+	Run  func(context.Context) error
+}
+
+// Scheduler runs tasks periodically on the only instance that holds the
+// leader lock, so several replicas don't do the same work.
+type Scheduler struct {
+	lock     leaderLock
+	interval time.Duration
+	tasks    []*Task
+}
+
+func New(lock leaderLock, interval time.Duration, tasks ...*Task) *Scheduler {
+	return &Scheduler{lock, interval, tasks}
+}
+
+// Run blocks until ctx is canceled. It lets the current round finish and
+// releases the leadership before returning.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := s.lock.Release(releaseCtx); err != nil {
+				log.Println(err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context) {
+	leader, err := s.lock.TryAcquire(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !leader {
+		return
+	}
+	for _, task := range s.tasks {
+		var (
+			start     = time.Now()
+			ctx, span = tracing.Start(ctx, "scheduler "+task.Name)
+			err       = task.Run(ctx)
+		)
+		tracing.End(span, err)
+		metrics.TaskDuration.WithLabelValues(task.Name).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.TaskRuns.WithLabelValues(task.Name, "failed").Inc()
+			log.Printf("scheduled task %s failed: %v", task.Name, err)
+			continue
+		}
+		metrics.TaskRuns.WithLabelValues(task.Name, "done").Inc()
+	}
+}
@@ -0,0 +1,74 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/tracing"
+)
+
+type idempotencyRepository interface {
+	Reserve(context.Context, *model.IdempotentRequest, time.Time, time.Time) (*model.IdempotentRequest, error)
+	Save(context.Context, *model.IdempotentRequest) error
+	Delete(context.Context, *model.IdempotentRequest) error
+	DeleteExpired(context.Context, time.Time) (int, error)
+}
+
+type Service struct {
+	repo        idempotencyRepository
+	window      time.Duration
+	lockTimeout time.Duration
+}
+
+// New creates the service which keeps responses for retries within window.
+// A request which hasn't been completed or aborted within lockTimeout
+// releases its key.
+func New(repo idempotencyRepository, window, lockTimeout time.Duration) *Service {
+	return &Service{repo, window, lockTimeout}
+}
+
+// Begin reserves the idempotency key for the request. If the key has already
+// been used within the window, it returns the stored response instead, or
+// ErrKeyReused if the key was used for another request and
+// ErrRequestInProgress if the first request isn't finished yet and its lock
+// hasn't timed out.
+func (s *Service) Begin(ctx context.Context, req *model.IdempotentRequest) (_ *model.IdempotentRequest, err error) {
+	ctx, span := tracing.Start(ctx, "idempotency.Begin")
+	defer func() { tracing.End(span, err) }()
+	now := time.Now()
+	stored, err := s.repo.Reserve(ctx, req, now.Add(-s.window), now.Add(-s.lockTimeout))
+	if err != nil || stored == nil {
+		return nil, err
+	}
+	if stored.Fingerprint != req.Fingerprint {
+		return nil, repository.ErrKeyReused
+	}
+	if stored.Status == 0 {
+		return nil, repository.ErrRequestInProgress
+	}
+	return stored, nil
+}
+
+// Complete stores the response of the request begun with Begin.
+func (s *Service) Complete(ctx context.Context, req *model.IdempotentRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "idempotency.Complete")
+	defer func() { tracing.End(span, err) }()
+	return s.repo.Save(ctx, req)
+}
+
+// Abort releases the key, so that the request can be retried.
+func (s *Service) Abort(ctx context.Context, req *model.IdempotentRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "idempotency.Abort")
+	defer func() { tracing.End(span, err) }()
+	return s.repo.Delete(ctx, req)
+}
+
+// Purge deletes keys which are out of the window. It returns the number of
+// deleted keys.
+func (s *Service) Purge(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "idempotency.Purge")
+	defer func() { tracing.End(span, err) }()
+	return s.repo.DeleteExpired(ctx, time.Now().Add(-s.window))
+}
@@ -0,0 +1,246 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/actor"
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type jobsRepository interface {
+	Create(context.Context, string, string) (uint64, error)
+	Get(context.Context, uint64) (*model.Job, error)
+	SetStatus(context.Context, uint64, model.JobStatus) error
+	SetTotal(context.Context, uint64, uint64) error
+	AddProgress(context.Context, uint64, uint64, uint64, []*model.JobError) error
+	Heartbeat(context.Context, string) error
+	FailExpired(context.Context, time.Duration) (int, error)
+}
+
+// Task is a heavy operation executed in background. It reports its progress
+// through Progress and fails the whole job by returning an error.
+type Task func(context.Context, *Progress) error
+
+type job struct {
+	id   uint64
+	task Task
+}
+
+// Service runs jobs of this instance. Every instance holds a lease on its
+// unfinished jobs by extending it periodically, so that jobs of instances
+// which have crashed or been killed are failed by the others when the lease
+// expires.
+type Service struct {
+	repo    jobsRepository
+	owner   string
+	lease   time.Duration
+	queue   chan *job
+	workers int
+	wg      *sync.WaitGroup
+	cancel  context.CancelFunc
+	// mu guards the queue from being sent to after it's closed by Stop
+	mu     sync.RWMutex
+	closed bool
+}
+
+func New(repo jobsRepository, workers, queueSize int, lease time.Duration) (*Service, error) {
+	owner, err := instanceID()
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		repo:    repo,
+		owner:   owner,
+		lease:   lease,
+		queue:   make(chan *job, queueSize),
+		workers: workers,
+		wg:      &sync.WaitGroup{},
+	}, nil
+}
+
+// instanceID identifies the running process among all instances, including
+// the previous runs of the same host.
+func instanceID() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("cannot generate instance id: %v", err)
+	}
+	return host + "-" + hex.EncodeToString(suffix), nil
+}
+
+// Start fails jobs with expired lease and runs the worker pool along with
+// the lease keeper.
+func (s *Service) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	if _, err := s.repo.FailExpired(ctx, s.lease); err != nil {
+		cancel()
+		return err
+	}
+	s.wg.Add(s.workers + 1)
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			defer s.wg.Done()
+			for j := range s.queue {
+				s.run(ctx, j)
+			}
+		}()
+	}
+	go func() {
+		defer s.wg.Done()
+		s.keepLease(ctx)
+	}()
+	return nil
+}
+
+// keepLease extends the lease of jobs of this instance several times per
+// lease period and fails expired jobs of others until ctx is canceled.
+func (s *Service) keepLease(ctx context.Context) {
+	ticker := time.NewTicker(s.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.repo.Heartbeat(ctx, s.owner); err != nil {
+			log.Println(err)
+		}
+		if count, err := s.repo.FailExpired(ctx, s.lease); err != nil {
+			log.Println(err)
+		} else if count > 0 {
+			log.Printf("%d jobs of stopped instances are failed", count)
+		}
+	}
+}
+
+// Stop cancels running jobs and waits for workers to exit. Jobs left in the
+// queue fail immediately with the canceled context, and jobs submitted
+// afterwards are rejected.
+func (s *Service) Stop() {
+	s.mu.Lock()
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Service) Submit(ctx context.Context, kind string, task Task) (_ uint64, err error) {
+	ctx, span := tracing.Start(ctx, "jobs.Submit")
+	defer func() { tracing.End(span, err) }()
+	id, err := s.repo.Create(ctx, kind, s.owner)
+	if err != nil {
+		return 0, err
+	}
+	// the job outlives the request, but changes it makes belong to the same
+	// actor and its trace is linked to the request's one
+	var (
+		a    = actor.From(ctx)
+		link = trace.LinkFromContext(ctx)
+	)
+	run := func(ctx context.Context, progress *Progress) error {
+		ctx, span := tracing.Tracer().Start(actor.With(ctx, a), "jobs."+kind, trace.WithLinks(link))
+		err := task(ctx, progress)
+		tracing.End(span, err)
+		return err
+	}
+	if s.enqueue(&job{id, run}) {
+		return id, nil
+	}
+	_ = s.repo.SetStatus(ctx, id, model.JobFailed)
+	return 0, repository.ErrJobQueueFull
+}
+
+// enqueue reports whether the job is queued. It fails if the queue is full
+// or the service is stopping, and another instance should take the job in
+// both cases.
+func (s *Service) enqueue(j *job) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return false
+	}
+	select {
+	case s.queue <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Service) Get(ctx context.Context, id uint64) (_ *model.Job, err error) {
+	ctx, span := tracing.Start(ctx, "jobs.Get")
+	defer func() { tracing.End(span, err) }()
+	return s.repo.Get(ctx, id)
+}
+
+func (s *Service) run(ctx context.Context, j *job) {
+	if err := s.repo.SetStatus(ctx, j.id, model.JobRunning); err != nil {
+		log.Println(err)
+	}
+	err := j.task(ctx, &Progress{s.repo, ctx, j.id})
+	// the job context may be canceled already, but the result still has to be saved
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	status := model.JobDone
+	if err != nil {
+		status = model.JobFailed
+		(&Progress{s.repo, ctx, j.id}).Fail("", 0, err)
+	}
+	if err := s.repo.SetStatus(ctx, j.id, status); err != nil {
+		log.Println(err)
+	}
+}
+
+// Progress reports the state of a running job. A nil Progress discards reports,
+// so the same code can be run synchronously.
+type Progress struct {
+	repo jobsRepository
+	ctx  context.Context
+	id   uint64
+}
+
+func (p *Progress) SetTotal(total uint64) {
+	if p == nil {
+		return
+	}
+	if err := p.repo.SetTotal(p.ctx, p.id, total); err != nil {
+		log.Println(err)
+	}
+}
+
+func (p *Progress) Done(count uint64) {
+	if p == nil {
+		return
+	}
+	if err := p.repo.AddProgress(p.ctx, p.id, count, 0, nil); err != nil {
+		log.Println(err)
+	}
+}
+
+// Fail records that count items of the job were processed with err.
+func (p *Progress) Fail(item string, count uint64, err error) {
+	if p == nil {
+		return
+	}
+	errs := []*model.JobError{{Item: item, Message: err.Error()}}
+	if err := p.repo.AddProgress(p.ctx, p.id, count, count, errs); err != nil {
+		log.Println(err)
+	}
+}
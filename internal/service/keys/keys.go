@@ -0,0 +1,75 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/tracing"
+)
+
+// tokenPrefix makes api keys recognizable, e.g. by secret scanners.
+const tokenPrefix = "sk_"
+
+type keysRepository interface {
+	Create(context.Context, *model.APIKey, string) error
+	GetByHash(context.Context, string) (*model.APIKey, error)
+	Revoke(context.Context, uint64) error
+}
+
+type Service struct {
+	repo keysRepository
+}
+
+func New(repo keysRepository) *Service {
+	return &Service{repo}
+}
+
+// Create generates a new token for the key and stores only its hash.
+// The token is returned to the caller once and can't be recovered later.
+func (s *Service) Create(ctx context.Context, key *model.APIKey) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "keys.Create")
+	defer func() { tracing.End(span, err) }()
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := tokenPrefix + hex.EncodeToString(raw)
+	if err := s.repo.Create(ctx, key, hash(token)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Bootstrap makes sure there's an admin key with the specified token, so
+// the very first keys can be created through the API.
+func (s *Service) Bootstrap(ctx context.Context, name, token string) (err error) {
+	ctx, span := tracing.Start(ctx, "keys.Bootstrap")
+	defer func() { tracing.End(span, err) }()
+	if _, err := s.repo.GetByHash(ctx, hash(token)); !errors.Is(err, repository.ErrKeyNotExists) {
+		return err
+	}
+	key := &model.APIKey{Name: name, Scopes: []model.Scope{model.ScopeAdmin}}
+	return s.repo.Create(ctx, key, hash(token))
+}
+
+func (s *Service) Authenticate(ctx context.Context, token string) (_ *model.APIKey, err error) {
+	ctx, span := tracing.Start(ctx, "keys.Authenticate")
+	defer func() { tracing.End(span, err) }()
+	return s.repo.GetByHash(ctx, hash(token))
+}
+
+func (s *Service) Revoke(ctx context.Context, id uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "keys.Revoke")
+	defer func() { tracing.End(span, err) }()
+	return s.repo.Revoke(ctx, id)
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
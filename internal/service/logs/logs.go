@@ -5,11 +5,13 @@ import (
 	"time"
 
 	"github.com/kiryu-dev/segments-api/internal/model"
-	"github.com/kiryu-dev/segments-api/pkg/util/csv"
+	"github.com/kiryu-dev/segments-api/internal/tracing"
+	"github.com/kiryu-dev/segments-api/pkg/util/export"
 )
 
 type logsRepository interface {
-	Read(context.Context, uint64, time.Time) ([]*model.UserLog, error)
+	Read(context.Context, uint64, time.Time, func(*model.UserLog) error) error
+	Query(context.Context, *model.LogsFilter) ([]*model.UserLog, error)
 }
 
 type Service struct {
@@ -20,14 +22,21 @@ func New(repo logsRepository) *Service {
 	return &Service{repo}
 }
 
-func (s *Service) GetUserLogs(ctx context.Context, userID uint64, date time.Time) (string, error) {
-	logs, err := s.repo.Read(ctx, userID, date)
-	if err != nil {
-		return "", err
+// ExportUserLogs writes logs of the user for the month of the date with the
+// writer. Rows are streamed as they're read, so memory usage doesn't depend
+// on the number of logs.
+func (s *Service) ExportUserLogs(ctx context.Context, userID uint64, date time.Time,
+	writer export.Writer[model.UserLog]) (err error) {
+	ctx, span := tracing.Start(ctx, "logs.ExportUserLogs")
+	defer func() { tracing.End(span, err) }()
+	if err := s.repo.Read(ctx, userID, date, writer.Write); err != nil {
+		return err
 	}
-	path, err := csv.GenerateCSV[model.UserLog](logs)
-	if err != nil {
-		return "", err
-	}
-	return path, nil
+	return writer.Close()
+}
+
+func (s *Service) GetLogs(ctx context.Context, filter *model.LogsFilter) (_ []*model.UserLog, err error) {
+	ctx, span := tracing.Start(ctx, "logs.GetLogs")
+	defer func() { tracing.End(span, err) }()
+	return s.repo.Query(ctx, filter)
 }
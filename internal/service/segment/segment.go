@@ -2,137 +2,491 @@ package segment
 
 import (
 	"context"
-	"sync"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/kiryu-dev/segments-api/internal/actor"
+	"github.com/kiryu-dev/segments-api/internal/metrics"
 	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/service/jobs"
+	"github.com/kiryu-dev/segments-api/internal/tracing"
+	"github.com/kiryu-dev/segments-api/pkg/util/batch"
+	"github.com/kiryu-dev/segments-api/pkg/util/bucket"
 	"github.com/kiryu-dev/segments-api/pkg/util/selector"
 )
 
 type segmentRepository interface {
-	Create(context.Context, string) error
-	Delete(context.Context, string) error
-	DeleteByTTL(context.Context) ([]*model.UserSegment, error)
-	GetUsersBySegment(context.Context, string) ([]uint64, error)
+	Create(context.Context, *model.Segment) error
+	Get(context.Context, string) (*model.SegmentStats, error)
+	Resolve(context.Context, []string) (map[string]*model.Segment, error)
+	GetDeleted(context.Context, string) (*model.Segment, error)
+	List(context.Context, string, string, int) ([]*model.SegmentStats, error)
+	GetMembers(context.Context, string, *model.MembersFilter) ([]*model.UserSegment, error)
+	Update(context.Context, uint64, *model.SegmentUpdate) error
+	SetState(context.Context, uint64, model.SegmentState, model.SegmentState) (bool, error)
+	Delete(context.Context, uint64) error
+	Discard(context.Context, uint64) error
+	Restore(context.Context, uint64) ([]uint64, error)
+	Purge(context.Context, time.Time) (int, error)
+	DeleteByTTL(context.Context, int) ([]*model.UserSegment, error)
+	ActivateScheduled(context.Context, int) ([]*model.UserSegment, error)
+	GetUsersBySegment(context.Context, uint64) ([]uint64, error)
 }
 
 type userRepository interface {
 	GetAll(context.Context) ([]uint64, error)
-	AddSegment(context.Context, *model.UserSegment) error
+	AddSegmentBulk(context.Context, []uint64, uint64, *time.Time) ([]uint64, error)
 }
 
 type logsRepository interface {
 	Write(context.Context, *model.UserLog) error
+	WriteBulk(context.Context, []uint64, *model.UserLog) error
+}
+
+type transactor interface {
+	WithinTx(context.Context, func(context.Context) error) error
+}
+
+type jobSubmitter interface {
+	Submit(context.Context, string, jobs.Task) (uint64, error)
 }
 
 type Service struct {
 	segment segmentRepository
 	user    userRepository
 	logs    logsRepository
+	tx      transactor
+	jobs    jobSubmitter
 }
 
-type userError struct {
-	id  uint64
-	err error
-}
+const batchSize = 5000
 
-func New(segment segmentRepository, user userRepository, logs logsRepository) *Service {
-	return &Service{segment, user, logs}
+func New(segment segmentRepository, user userRepository, logs logsRepository,
+	tx transactor, jobs jobSubmitter) *Service {
+	return &Service{segment, user, logs, tx, jobs}
 }
 
-func (s *Service) Create(ctx context.Context, slug string, percentage float64) ([]uint64, error) {
-	err := s.segment.Create(ctx, slug)
-	if percentage == 0 || err != nil {
+// Create creates the segment and adds it to the specified percentage of
+// users unless it's a draft. It returns ids of users the segment was added to.
+func (s *Service) Create(ctx context.Context, seg *model.Segment) (_ []uint64, err error) {
+	ctx, span := tracing.Start(ctx, "segment.Create")
+	defer func() { tracing.End(span, err) }()
+	if err := s.create(ctx, seg); seg.Percentage == 0 || seg.State == model.DraftState || err != nil {
 		return nil, err
 	}
+	return s.rollout(ctx, seg, nil)
+}
+
+// CreateAsync creates the segment and starts a job adding it to the specified
+// percentage of users. It returns id of the job. If the job can't be started,
+// the segment is discarded, so the request can be retried.
+func (s *Service) CreateAsync(ctx context.Context, seg *model.Segment) (_ uint64, err error) {
+	ctx, span := tracing.Start(ctx, "segment.CreateAsync")
+	defer func() { tracing.End(span, err) }()
+	if err := s.create(ctx, seg); err != nil {
+		return 0, err
+	}
+	id, err := s.jobs.Submit(ctx, "segment_rollout", func(ctx context.Context, progress *jobs.Progress) error {
+		_, err := s.rollout(ctx, seg, progress)
+		return err
+	})
+	if err != nil {
+		// without its rollout the segment is useless, and it would make the
+		// retry fail because it already exists
+		if discardErr := s.segment.Discard(context.WithoutCancel(ctx), seg.ID); discardErr != nil {
+			return 0, errors.Join(err, discardErr)
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// create stores the segment owned by the namespace of the actor unless
+// another owner is specified, which only admins are allowed to do.
+func (s *Service) create(ctx context.Context, seg *model.Segment) error {
+	if a := actor.From(ctx); seg.Owner == "" && a != nil {
+		seg.Owner = a.Namespace
+	}
+	if !actor.CanChange(ctx, seg.Owner) {
+		return repository.ErrForbidden
+	}
+	if seg.Rollout == model.HashRollout {
+		salt, err := bucket.NewSalt()
+		if err != nil {
+			return err
+		}
+		seg.Salt = salt
+	}
+	return s.segment.Create(ctx, seg)
+}
+
+func (s *Service) rollout(ctx context.Context, seg *model.Segment, progress *jobs.Progress) ([]uint64, error) {
 	users, err := s.user.GetAll(ctx)
 	if err != nil {
 		return nil, err
 	}
-	count := len(users)
-	if percentage != 100 {
-		count = int(percentage / 100. * float64(count))
-		if count == 0 {
-			return nil, nil
+	users, err = selectUsers(users, seg)
+	if err != nil {
+		return nil, err
+	}
+	progress.SetTotal(uint64(len(users)))
+	return s.addToUsers(ctx, users, seg, progress), nil
+}
+
+// selectUsers picks the users that fall into the segment's rollout. Hash rollout
+// is deterministic, so the same users are selected by every recomputation.
+func selectUsers(users []uint64, seg *model.Segment) ([]uint64, error) {
+	if seg.Rollout == model.HashRollout {
+		result := make([]uint64, 0)
+		for _, id := range users {
+			if bucket.Contains(id, seg.Salt, seg.Percentage) {
+				result = append(result, id)
+			}
 		}
-		users, err = selector.Select(users, count)
+		return result, nil
+	}
+	if seg.Percentage == 100 {
+		return users, nil
+	}
+	count := int(seg.Percentage / 100. * float64(len(users)))
+	if count == 0 {
+		return nil, nil
+	}
+	return selector.Select(users, count)
+}
+
+// addToUsers adds the segment to users batch by batch, each batch is written
+// with its logs in its own transaction. A failed batch doesn't stop the others.
+func (s *Service) addToUsers(ctx context.Context, users []uint64, seg *model.Segment,
+	progress *jobs.Progress) []uint64 {
+	var (
+		result      = make([]uint64, 0)
+		requestTime = time.Now()
+	)
+	for _, b := range batch.Split(users, batchSize) {
+		var added []uint64
+		err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+			var err error
+			if added, err = s.user.AddSegmentBulk(ctx, b, seg.ID, nil); err != nil || len(added) == 0 {
+				return err
+			}
+			return s.logs.WriteBulk(ctx, added, &model.UserLog{
+				SegmentID:   seg.ID,
+				Slug:        seg.Slug,
+				Operation:   model.AddOp.String(),
+				RequestTime: requestTime,
+				Reason:      model.ReasonRollout,
+				Actor:       actor.Name(ctx),
+			})
+		})
 		if err != nil {
-			return nil, err
+			progress.Fail(fmt.Sprintf("users %d-%d", b[0], b[len(b)-1]), uint64(len(b)), err)
+			continue
 		}
+		progress.Done(uint64(len(b)))
+		result = append(result, added...)
 	}
-	result := make([]uint64, 0)
-	for e := range s.addSegmentToUsers(ctx, users, slug) {
-		if e.err == nil {
-			result = append(result, e.id)
-		}
+	return result
+}
+
+func (s *Service) Get(ctx context.Context, slug string) (_ *model.SegmentStats, err error) {
+	ctx, span := tracing.Start(ctx, "segment.Get")
+	defer func() { tracing.End(span, err) }()
+	return s.segment.Get(ctx, slug)
+}
+
+func (s *Service) List(ctx context.Context, prefix, cursor string, limit int) (_ []*model.SegmentStats, err error) {
+	ctx, span := tracing.Start(ctx, "segment.List")
+	defer func() { tracing.End(span, err) }()
+	return s.segment.List(ctx, prefix, cursor, limit)
+}
+
+func (s *Service) GetMembers(ctx context.Context, slug string,
+	filter *model.MembersFilter) (_ []*model.UserSegment, err error) {
+	ctx, span := tracing.Start(ctx, "segment.GetMembers")
+	defer func() { tracing.End(span, err) }()
+	members, err := s.segment.GetMembers(ctx, slug, filter)
+	if err != nil || len(members) > 0 {
+		return members, err
+	}
+	// an empty page is fine unless the segment itself doesn't exist
+	if _, err := s.segment.Get(ctx, slug); err != nil {
+		return nil, err
 	}
-	return result, nil
+	return members, nil
 }
 
-func (s *Service) addSegmentToUsers(ctx context.Context, users []uint64, slug string) <-chan *userError {
+// Update changes metadata, lifecycle state or name of the segment keeping
+// its memberships and history. Activated draft is rolled out to the
+// percentage of users in a job, and a segment can't become a draft again
+// after that. Only admin can hand the segment over to another namespace.
+// It returns the updated segment and id of the rollout job if it's started.
+func (s *Service) Update(ctx context.Context, slug string,
+	upd *model.SegmentUpdate) (_ *model.SegmentStats, jobID uint64, err error) {
+	ctx, span := tracing.Start(ctx, "segment.Update")
+	defer func() { tracing.End(span, err) }()
+	seg, err := s.checkOwner(ctx, slug)
+	if err != nil {
+		return nil, 0, err
+	}
+	if upd.Owner != nil && !actor.CanChange(ctx, *upd.Owner) {
+		return nil, 0, repository.ErrForbidden
+	}
+	if upd.State != nil && *upd.State == model.DraftState && seg.State != model.DraftState {
+		return nil, 0, repository.ErrSegmentActivated
+	}
 	var (
-		wg  = &sync.WaitGroup{}
-		out = make(chan *userError)
+		fields    = *upd
+		activate  = upd.State != nil && *upd.State == model.ActiveState && seg.State == model.DraftState
+		activated bool
 	)
-	wg.Add(len(users))
-	for _, user := range users {
-		go func(ctx context.Context, userID uint64) {
-			defer wg.Done()
-			err := s.user.AddSegment(ctx, &model.UserSegment{
-				UserID: userID,
-				Slug:   slug,
-			})
-			if err == nil {
-				_ = s.logs.Write(ctx, &model.UserLog{
-					UserID:      userID,
-					Slug:        slug,
-					Operation:   model.AddOp.String(),
-					RequestTime: time.Now(),
-				})
-			}
-			out <- &userError{
-				id:  userID,
-				err: err,
-			}
-		}(ctx, user)
+	if activate {
+		// the draft is activated only if it's still a draft, so concurrent
+		// requests can't roll it out twice
+		fields.State = nil
+	}
+	err = s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.segment.Update(ctx, seg.ID, &fields); err != nil || !activate {
+			return err
+		}
+		var err error
+		activated, err = s.segment.SetState(ctx, seg.ID, model.DraftState, model.ActiveState)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if activated && seg.Percentage > 0 {
+		if jobID, err = s.activate(ctx, seg); err != nil {
+			return nil, 0, err
+		}
+	}
+	if upd.Slug != nil {
+		slug = *upd.Slug
 	}
-	go func() {
-		wg.Wait()
-		close(out)
-	}()
-	return out
+	stats, err := s.segment.Get(ctx, slug)
+	return stats, jobID, err
 }
 
-func (s *Service) Delete(ctx context.Context, slug string) error {
-	users, _ := s.segment.GetUsersBySegment(ctx, slug)
-	if err := s.segment.Delete(ctx, slug); err != nil {
+// activate starts a job rolling the activated draft out. If the job can't be
+// started, the segment is moved back to draft, so the activation can be
+// retried.
+func (s *Service) activate(ctx context.Context, seg *model.Segment) (uint64, error) {
+	id, err := s.jobs.Submit(ctx, "segment_rollout", func(ctx context.Context, progress *jobs.Progress) error {
+		_, err := s.rollout(ctx, seg, progress)
 		return err
+	})
+	if err == nil {
+		return id, nil
 	}
-	for _, id := range users {
-		_ = s.logs.Write(ctx, &model.UserLog{
-			UserID:      id,
-			Slug:        slug,
-			Operation:   model.DeleteOp.String(),
-			RequestTime: time.Now(),
-		})
+	_, revertErr := s.segment.SetState(context.WithoutCancel(ctx), seg.ID, model.ActiveState, model.DraftState)
+	return 0, errors.Join(err, revertErr)
+}
+
+func (s *Service) Delete(ctx context.Context, slug string) (err error) {
+	ctx, span := tracing.Start(ctx, "segment.Delete")
+	defer func() { tracing.End(span, err) }()
+	seg, err := s.checkOwner(ctx, slug)
+	if err != nil {
+		return err
 	}
-	return nil
+	return s.delete(ctx, seg, nil)
+}
+
+// DeleteAsync starts a job deleting the segment and writing logs for all of
+// its users. It returns id of the job.
+func (s *Service) DeleteAsync(ctx context.Context, slug string) (_ uint64, err error) {
+	ctx, span := tracing.Start(ctx, "segment.DeleteAsync")
+	defer func() { tracing.End(span, err) }()
+	seg, err := s.checkOwner(ctx, slug)
+	if err != nil {
+		return 0, err
+	}
+	return s.jobs.Submit(ctx, "segment_delete", func(ctx context.Context, progress *jobs.Progress) error {
+		return s.delete(ctx, seg, progress)
+	})
+}
+
+// checkOwner resolves the segment by its slug. It returns an error if the
+// segment doesn't exist or the actor isn't allowed to change it.
+func (s *Service) checkOwner(ctx context.Context, slug string) (*model.Segment, error) {
+	segments, err := s.segment.Resolve(ctx, []string{slug})
+	if err != nil {
+		return nil, err
+	}
+	seg, ok := segments[slug]
+	if !ok {
+		return nil, repository.ErrSegmentNotExists
+	}
+	if !actor.CanChange(ctx, seg.Owner) {
+		return nil, repository.ErrForbidden
+	}
+	return seg, nil
 }
 
-func (s *Service) DeleteByTTL() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	segments, err := s.segment.DeleteByTTL(ctx)
+func (s *Service) delete(ctx context.Context, seg *model.Segment, progress *jobs.Progress) error {
+	var users []uint64
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		users, err = s.segment.GetUsersBySegment(ctx, seg.ID)
+		if err != nil && !errors.Is(err, repository.ErrNoUsers) {
+			return err
+		}
+		progress.SetTotal(uint64(len(users)))
+		if err := s.segment.Delete(ctx, seg.ID); err != nil {
+			return err
+		}
+		requestTime := time.Now()
+		for _, b := range batch.Split(users, batchSize) {
+			err := s.logs.WriteBulk(ctx, b, &model.UserLog{
+				SegmentID:   seg.ID,
+				Slug:        seg.Slug,
+				Operation:   model.DeleteOp.String(),
+				RequestTime: requestTime,
+				Reason:      model.ReasonSegmentDeleted,
+				Actor:       actor.Name(ctx),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	for _, segment := range segments {
-		_ = s.logs.Write(ctx, &model.UserLog{
-			UserID:      segment.UserID,
-			Slug:        segment.Slug,
-			Operation:   model.DeleteOp.String(),
-			RequestTime: time.Now(),
+	progress.Done(uint64(len(users)))
+	return nil
+}
+
+// Restore brings back the deleted segment with its former members and
+// writes add logs for them. It returns ids of users the segment is restored to.
+func (s *Service) Restore(ctx context.Context, slug string) (_ []uint64, err error) {
+	ctx, span := tracing.Start(ctx, "segment.Restore")
+	defer func() { tracing.End(span, err) }()
+	seg, err := s.segment.GetDeleted(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if !actor.CanChange(ctx, seg.Owner) {
+		return nil, repository.ErrForbidden
+	}
+	var users []uint64
+	err = s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		if users, err = s.segment.Restore(ctx, seg.ID); err != nil {
+			return err
+		}
+		requestTime := time.Now()
+		for _, b := range batch.Split(users, batchSize) {
+			err := s.logs.WriteBulk(ctx, b, &model.UserLog{
+				SegmentID:   seg.ID,
+				Slug:        seg.Slug,
+				Operation:   model.AddOp.String(),
+				RequestTime: requestTime,
+				Reason:      model.ReasonSegmentRestored,
+				Actor:       actor.Name(ctx),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Purge permanently deletes segments which were deleted more than retention
+// ago. It returns the number of purged segments.
+func (s *Service) Purge(ctx context.Context, retention time.Duration) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "segment.Purge")
+	defer func() { tracing.End(span, err) }()
+	return s.segment.Purge(ctx, time.Now().Add(-retention))
+}
+
+// DeleteByTTL deletes time expired segments of users in batches of batchSize,
+// each batch with its logs in its own transaction. It returns the number of
+// deleted segments.
+func (s *Service) DeleteByTTL(ctx context.Context, batchSize int) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "segment.DeleteByTTL")
+	defer func() { tracing.End(span, err) }()
+	total := 0
+	for {
+		var segments []*model.UserSegment
+		err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+			var err error
+			if segments, err = s.segment.DeleteByTTL(ctx, batchSize); err != nil {
+				return err
+			}
+			requestTime := time.Now()
+			for _, segment := range segments {
+				err := s.logs.Write(ctx, &model.UserLog{
+					UserID:      segment.UserID,
+					SegmentID:   segment.SegmentID,
+					Slug:        segment.Slug,
+					Operation:   model.DeleteOp.String(),
+					RequestTime: requestTime,
+					Reason:      model.ReasonTTL,
+					Actor:       actor.Name(ctx),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
 		})
+		if err != nil {
+			return total, err
+		}
+		total += len(segments)
+		metrics.ExpiredMemberships.Add(float64(len(segments)))
+		if len(segments) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// ActivateScheduled writes add logs for segments of users which start time
+// has come. It's done in batches of batchSize like DeleteByTTL and returns
+// the number of activated segments.
+func (s *Service) ActivateScheduled(ctx context.Context, batchSize int) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "segment.ActivateScheduled")
+	defer func() { tracing.End(span, err) }()
+	total := 0
+	for {
+		var segments []*model.UserSegment
+		err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+			var err error
+			if segments, err = s.segment.ActivateScheduled(ctx, batchSize); err != nil {
+				return err
+			}
+			for _, segment := range segments {
+				err := s.logs.Write(ctx, &model.UserLog{
+					UserID:      segment.UserID,
+					SegmentID:   segment.SegmentID,
+					Slug:        segment.Slug,
+					Operation:   model.AddOp.String(),
+					RequestTime: *segment.StartTime,
+					Reason:      model.ReasonSchedule,
+					Actor:       actor.Name(ctx),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += len(segments)
+		if len(segments) < batchSize {
+			return total, nil
+		}
 	}
-	return nil
 }
@@ -2,75 +2,429 @@ package user
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"sync"
 	"time"
 
+	"github.com/kiryu-dev/segments-api/internal/actor"
+	"github.com/kiryu-dev/segments-api/internal/metrics"
 	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/service/jobs"
+	"github.com/kiryu-dev/segments-api/internal/tracing"
+	"github.com/kiryu-dev/segments-api/pkg/util/batch"
+	"github.com/kiryu-dev/segments-api/pkg/util/bucket"
+	"github.com/kiryu-dev/segments-api/pkg/util/selector"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type userRepository interface {
 	Create(context.Context, uint64) error
 	Delete(context.Context, uint64) error
 	GetUserSegments(context.Context, uint64) ([]string, error)
+	GetUserSegmentsDetailed(context.Context, uint64) ([]*model.UserSegment, error)
 	AddSegment(context.Context, *model.UserSegment) error
-	DeleteSegment(context.Context, *model.UserSegment) error
+	DeleteSegment(context.Context, *model.UserSegment) (bool, error)
+	AddSegmentBulk(context.Context, []uint64, uint64, *time.Time) ([]uint64, error)
+	DeleteSegmentBulk(context.Context, []uint64, uint64) ([]uint64, error)
+	SetDeleteTime(context.Context, *model.UserSegment) error
+	ExtendDeleteTime(context.Context, *model.UserSegment, string) error
+}
+
+type segmentRepository interface {
+	GetRollouts(context.Context) ([]*model.Segment, error)
+	Resolve(context.Context, []string) (map[string]*model.Segment, error)
 }
 
 type logsRepository interface {
 	Write(context.Context, *model.UserLog) error
+	WriteBulk(context.Context, []uint64, *model.UserLog) error
+}
+
+type transactor interface {
+	WithinTx(context.Context, func(context.Context) error) error
+}
+
+type jobSubmitter interface {
+	Submit(context.Context, string, jobs.Task) (uint64, error)
 }
 
 type Service struct {
-	user userRepository
-	logs logsRepository
+	user    userRepository
+	segment segmentRepository
+	logs    logsRepository
+	tx      transactor
+	jobs    jobSubmitter
 }
 
+const bulkBatchSize = 5000
+
 type segmentError struct {
 	idx int
 	err error
 }
 
-type changeFunc func(context.Context, *model.UserSegment) error
+// changeFunc applies the change and reports whether membership of the user
+// has changed, so that only actual changes are logged. Deleting a scheduled
+// segment which hasn't started isn't a change, since its addition isn't
+// logged either.
+type changeFunc func(context.Context, *model.UserSegment) (bool, error)
+
+func New(user userRepository, segment segmentRepository, logs logsRepository,
+	tx transactor, jobs jobSubmitter) *Service {
+	return &Service{user, segment, logs, tx, jobs}
+}
+
+// Create creates the user and places it into every percentage segment whose
+// rollout rule matches the user. It returns the segments the user was added to.
+func (s *Service) Create(ctx context.Context, userID uint64) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "user.Create")
+	defer func() { tracing.End(span, err) }()
+	var enrolled []string
+	err = s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.user.Create(ctx, userID); err != nil {
+			return err
+		}
+		var err error
+		enrolled, err = s.enroll(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return enrolled, nil
+}
 
-func New(user userRepository, logs logsRepository) *Service {
-	return &Service{user, logs}
+func (s *Service) enroll(ctx context.Context, userID uint64) ([]string, error) {
+	segments, err := s.segment.GetRollouts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		enrolled    = make([]string, 0)
+		requestTime = time.Now()
+	)
+	for _, seg := range segments {
+		ok, err := matchRollout(userID, seg)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		segment := &model.UserSegment{
+			UserID:    userID,
+			SegmentID: seg.ID,
+			Slug:      seg.Slug,
+		}
+		if err := s.changeWithLog(ctx, segment, model.AddOp, requestTime, model.ReasonRollout); err != nil {
+			return nil, err
+		}
+		enrolled = append(enrolled, seg.Slug)
+	}
+	return enrolled, nil
 }
 
-func (s *Service) Create(ctx context.Context, userID uint64) error {
-	return s.user.Create(ctx, userID)
+// matchRollout decides whether a new user falls into the segment. For random
+// rollout every user is drawn independently, so the segment keeps its share
+// of the growing user base.
+func matchRollout(userID uint64, seg *model.Segment) (bool, error) {
+	if seg.Rollout == model.HashRollout {
+		return bucket.Contains(userID, seg.Salt, seg.Percentage), nil
+	}
+	return selector.Draw(seg.Percentage)
 }
 
-func (s *Service) Delete(ctx context.Context, userID uint64) error {
-	slugs, _ := s.user.GetUserSegments(ctx, userID)
+func (s *Service) Delete(ctx context.Context, userID uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "user.Delete")
+	defer func() { tracing.End(span, err) }()
+	segments, _ := s.user.GetUserSegmentsDetailed(ctx, userID)
 	if err := s.user.Delete(ctx, userID); err != nil {
 		return err
 	}
-	for _, slug := range slugs {
-		_ = s.logs.Write(ctx, &model.UserLog{
+	for _, segment := range segments {
+		s.writeLog(ctx, &model.UserLog{
 			UserID:      userID,
-			Slug:        slug,
+			SegmentID:   segment.SegmentID,
+			Slug:        segment.Slug,
 			Operation:   model.DeleteOp.String(),
 			RequestTime: time.Now(),
+			Reason:      model.ReasonUserDeleted,
+			Actor:       actor.Name(ctx),
 		})
 	}
 	return nil
 }
 
-func (s *Service) GetUserSegments(ctx context.Context, userID uint64) ([]string, error) {
+func (s *Service) GetUserSegments(ctx context.Context, userID uint64) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "user.GetUserSegments")
+	defer func() { tracing.End(span, err) }()
 	return s.user.GetUserSegments(ctx, userID)
 }
 
-func (s *Service) Change(ctx context.Context, seg []*model.UserSegment, opType model.OpType) []error {
+func (s *Service) GetUserSegmentsDetailed(ctx context.Context, userID uint64) (_ []*model.UserSegment, err error) {
+	ctx, span := tracing.Start(ctx, "user.GetUserSegmentsDetailed")
+	defer func() { tracing.End(span, err) }()
+	return s.user.GetUserSegmentsDetailed(ctx, userID)
+}
+
+func (s *Service) SetDeleteTime(ctx context.Context, seg *model.UserSegment) (err error) {
+	ctx, span := tracing.Start(ctx, "user.SetDeleteTime")
+	defer func() { tracing.End(span, err) }()
+	if err := s.checkOwners(ctx, seg)[0]; err != nil {
+		return err
+	}
+	return s.user.SetDeleteTime(ctx, seg)
+}
+
+func (s *Service) ExtendDeleteTime(ctx context.Context, seg *model.UserSegment, ttl string) (err error) {
+	ctx, span := tracing.Start(ctx, "user.ExtendDeleteTime")
+	defer func() { tracing.End(span, err) }()
+	if err := s.checkOwners(ctx, seg)[0]; err != nil {
+		return err
+	}
+	return s.user.ExtendDeleteTime(ctx, seg, ttl)
+}
+
+// Change applies changes concurrently, each one independently of the others.
+// Segments the actor isn't allowed to change are skipped with ErrForbidden.
+func (s *Service) Change(ctx context.Context, seg []*model.UserSegment, opType model.OpType) (result []error) {
+	ctx, span := tracing.Start(ctx, "user.Change",
+		attribute.String("operation", opType.String()),
+		attribute.Int("segments", len(seg)),
+	)
+	defer func() { tracing.End(span, errors.Join(result...)) }()
+	result = s.checkOwners(ctx, seg...)
 	var (
-		result  = make([]error, len(seg))
-		errChan = s.changeSegments(ctx, seg, opType)
+		allowed = make([]*model.UserSegment, 0, len(seg))
+		indices = make([]int, 0, len(seg))
 	)
-	for e := range errChan {
-		result[e.idx] = e.err
+	for i, segment := range seg {
+		if result[i] == nil {
+			allowed = append(allowed, segment)
+			indices = append(indices, i)
+		}
+	}
+	for e := range s.changeSegments(ctx, allowed, opType) {
+		result[indices[e.idx]] = e.err
 	}
 	return result
 }
 
+// ChangeAtomic applies all additions and deletions with their logs in a single
+// transaction. If any of them fails, nothing is applied: the failed change
+// gets its own error and the others get repository.ErrRolledBack.
+func (s *Service) ChangeAtomic(ctx context.Context, toAdd, toDelete []*model.UserSegment) (addErr, delErr []error) {
+	ctx, span := tracing.Start(ctx, "user.ChangeAtomic")
+	defer func() { tracing.End(span, errors.Join(errors.Join(addErr...), errors.Join(delErr...))) }()
+	addErr = s.checkOwners(ctx, toAdd...)
+	delErr = s.checkOwners(ctx, toDelete...)
+	for _, errs := range [][]error{addErr, delErr} {
+		for _, err := range errs {
+			if err != nil {
+				markRolledBack(addErr, delErr, err)
+				return addErr, delErr
+			}
+		}
+	}
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		requestTime := time.Now()
+		for i, segment := range toAdd {
+			if addErr[i] = s.changeWithLog(ctx, segment, model.AddOp, requestTime, model.ReasonAPI); addErr[i] != nil {
+				return addErr[i]
+			}
+		}
+		for i, segment := range toDelete {
+			if delErr[i] = s.changeWithLog(ctx, segment, model.DeleteOp, requestTime, model.ReasonAPI); delErr[i] != nil {
+				return delErr[i]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		markRolledBack(addErr, delErr, err)
+	}
+	return addErr, delErr
+}
+
+// checkOwners resolves ids of segments by their slugs and returns an error for
+// every segment the actor isn't allowed to change. Segments which don't exist
+// are left to fail on the change itself.
+func (s *Service) checkOwners(ctx context.Context, segments ...*model.UserSegment) []error {
+	var (
+		result = make([]error, len(segments))
+		slugs  = make([]string, len(segments))
+	)
+	if len(segments) == 0 {
+		return result
+	}
+	for i, segment := range segments {
+		slugs[i] = segment.Slug
+	}
+	resolved, err := s.segment.Resolve(ctx, slugs)
+	for i, segment := range segments {
+		if err != nil {
+			result[i] = err
+			continue
+		}
+		seg, ok := resolved[segment.Slug]
+		if !ok {
+			continue
+		}
+		segment.SegmentID = seg.ID
+		if !actor.CanChange(ctx, seg.Owner) {
+			result[i] = repository.ErrForbidden
+		}
+	}
+	return result
+}
+
+func (s *Service) changeWithLog(ctx context.Context, segment *model.UserSegment,
+	opType model.OpType, requestTime time.Time, reason model.LogReason) error {
+	changed, err := s.defineChangeFunc(opType)(ctx, segment)
+	if err != nil || !changed {
+		return err
+	}
+	if segment.StartTime != nil {
+		// scheduled segment is logged by the sweeper when it becomes active
+		return nil
+	}
+	return s.logs.Write(ctx, &model.UserLog{
+		UserID:      segment.UserID,
+		SegmentID:   segment.SegmentID,
+		Slug:        segment.Slug,
+		Operation:   opType.String(),
+		RequestTime: requestTime,
+		Reason:      reason,
+		Actor:       actor.Name(ctx),
+	})
+}
+
+// writeLog writes the log of a change which is already applied, so a failure
+// doesn't fail the change and is only reported.
+func (s *Service) writeLog(ctx context.Context, userLog *model.UserLog) {
+	if err := s.logs.Write(ctx, userLog); err != nil {
+		metrics.LogWriteFailures.WithLabelValues(string(userLog.Reason)).Inc()
+		log.Printf("cannot write log of user %d segment %s: %v", userLog.UserID, userLog.Slug, err)
+	}
+}
+
+// markRolledBack fills the errors of changes that succeeded before the
+// transaction was aborted. If no change failed, the transaction itself did
+// (e.g. on commit), so txErr is reported for every change.
+func markRolledBack(addErr, delErr []error, txErr error) {
+	cause := txErr
+	for _, errs := range [][]error{addErr, delErr} {
+		for _, err := range errs {
+			if err != nil {
+				cause = repository.ErrRolledBack
+			}
+		}
+	}
+	for _, errs := range [][]error{addErr, delErr} {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = cause
+			}
+		}
+	}
+}
+
+// ChangeBulk applies every change to all specified users. Each change is
+// applied in its own transaction with batched statements, so a failed change
+// doesn't affect the others.
+func (s *Service) ChangeBulk(ctx context.Context, userIDs []uint64, changes []*model.BulkChange) []*model.BulkResult {
+	ctx, span := tracing.Start(ctx, "user.ChangeBulk")
+	var failed []error
+	defer func() { tracing.End(span, errors.Join(failed...)) }()
+	result := make([]*model.BulkResult, len(changes))
+	for i, change := range changes {
+		result[i] = &model.BulkResult{Change: change}
+		err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+			users, err := s.changeBulk(ctx, userIDs, change)
+			result[i].Users = users
+			return err
+		})
+		if err != nil {
+			result[i].Users, result[i].Err = nil, err
+			failed = append(failed, err)
+		}
+	}
+	return result
+}
+
+// ChangeBulkAsync starts a job applying every change to all specified users.
+// It returns id of the job.
+func (s *Service) ChangeBulkAsync(ctx context.Context, userIDs []uint64, changes []*model.BulkChange) (_ uint64, err error) {
+	ctx, span := tracing.Start(ctx, "user.ChangeBulkAsync")
+	defer func() { tracing.End(span, err) }()
+	return s.jobs.Submit(ctx, "bulk_change", func(ctx context.Context, progress *jobs.Progress) error {
+		progress.SetTotal(uint64(len(userIDs) * len(changes)))
+		for _, change := range changes {
+			err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+				_, err := s.changeBulk(ctx, userIDs, change)
+				return err
+			})
+			if err != nil {
+				item := fmt.Sprintf("%s %s", change.Operation, change.Slug)
+				progress.Fail(item, uint64(len(userIDs)), err)
+				continue
+			}
+			progress.Done(uint64(len(userIDs)))
+		}
+		return nil
+	})
+}
+
+func (s *Service) changeBulk(ctx context.Context, userIDs []uint64, change *model.BulkChange) ([]uint64, error) {
+	segment := &model.UserSegment{Slug: change.Slug}
+	if err := s.checkOwners(ctx, segment)[0]; err != nil {
+		return nil, err
+	}
+	if segment.SegmentID == 0 {
+		// otherwise deleting a segment which doesn't exist would look like
+		// none of the users had it
+		return nil, repository.ErrSegmentNotExists
+	}
+	var (
+		changed     = make([]uint64, 0)
+		requestTime = time.Now()
+	)
+	for _, b := range batch.Split(userIDs, bulkBatchSize) {
+		var (
+			users []uint64
+			err   error
+		)
+		switch change.Operation {
+		case model.AddOp:
+			users, err = s.user.AddSegmentBulk(ctx, b, segment.SegmentID, change.DeleteTime)
+		case model.DeleteOp:
+			users, err = s.user.DeleteSegmentBulk(ctx, b, segment.SegmentID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			continue
+		}
+		err = s.logs.WriteBulk(ctx, users, &model.UserLog{
+			SegmentID:   segment.SegmentID,
+			Slug:        change.Slug,
+			Operation:   change.Operation.String(),
+			RequestTime: requestTime,
+			Reason:      model.ReasonAPI,
+			Actor:       actor.Name(ctx),
+		})
+		if err != nil {
+			return nil, err
+		}
+		changed = append(changed, users...)
+	}
+	return changed, nil
+}
+
 func (s *Service) changeSegments(ctx context.Context, seg []*model.UserSegment,
 	opType model.OpType) <-chan *segmentError {
 	var (
@@ -83,15 +437,24 @@ func (s *Service) changeSegments(ctx context.Context, seg []*model.UserSegment,
 	for i, segment := range seg {
 		go func(ctx context.Context, i int, segment *model.UserSegment) {
 			defer wg.Done()
-			err := fn(ctx, segment)
-			if err == nil {
-				_ = s.logs.Write(ctx, &model.UserLog{
+			ctx, span := tracing.Start(ctx, "user.changeSegment",
+				attribute.Int64("user_id", int64(segment.UserID)),
+				attribute.String("segment", segment.Slug),
+				attribute.String("operation", operation),
+			)
+			changed, err := fn(ctx, segment)
+			if changed && segment.StartTime == nil {
+				s.writeLog(ctx, &model.UserLog{
 					UserID:      segment.UserID,
+					SegmentID:   segment.SegmentID,
 					Slug:        segment.Slug,
 					Operation:   operation,
 					RequestTime: time.Now(),
+					Reason:      model.ReasonAPI,
+					Actor:       actor.Name(ctx),
 				})
 			}
+			tracing.End(span, err)
 			out <- &segmentError{
 				idx: i,
 				err: err,
@@ -109,9 +472,21 @@ func (s *Service) defineChangeFunc(opType model.OpType) changeFunc {
 	var fn changeFunc
 	switch opType {
 	case model.AddOp:
-		fn = s.user.AddSegment
+		fn = s.addSegment
 	case model.DeleteOp:
 		fn = s.user.DeleteSegment
 	}
 	return fn
 }
+
+// addSegment adds the segment to the user. A segment the user already has is
+// an error only with ConflictError policy, otherwise it's left unchanged or
+// its delete time is refreshed by the repository.
+func (s *Service) addSegment(ctx context.Context, segment *model.UserSegment) (bool, error) {
+	err := s.user.AddSegment(ctx, segment)
+	if errors.Is(err, repository.ErrHasSegment) &&
+		(segment.OnConflict == model.ConflictIgnore || segment.OnConflict == model.ConflictRefresh) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,64 @@
+package get_job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+)
+
+type jobGetter interface {
+	Get(context.Context, uint64) (*model.Job, error)
+}
+
+// GetJob godoc
+//
+//	@Summary		Получить состояние фоновой задачи
+//	@Description	Метод получения состояния фоновой задачи (добавление сегмента проценту пользователей, массовое изменение сегментов пользователей, удаление сегмента): статус, общее количество элементов, количество обработанных и завершившихся ошибкой элементов, а также список ошибок.
+//	@Tags			jobs
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id		path		int						true	"job id"
+//	@Success		200		{object}	model.Job				"job state"
+//	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		404		{object}	handlers.responseError	"error"
+//	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Router			/jobs/{id} [get]
+func New(service jobGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, "invalid job id")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		job, err := service.Get(ctx, id)
+		if errors.Is(err, repository.ErrJobNotExists) {
+			w.WriteHeader(http.StatusNotFound)
+			handlers.WriteJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package create_key
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+	"github.com/kiryu-dev/segments-api/internal/transport/validation"
+)
+
+type keyCreator interface {
+	Create(context.Context, *model.APIKey) (string, error)
+}
+
+type request struct {
+	Name      string        `json:"name"`
+	Namespace string        `json:"namespace"`
+	Scopes    []model.Scope `json:"scopes"`
+}
+
+type response struct {
+	*model.APIKey
+	Key string `json:"key"`
+}
+
+// CreateKey godoc
+//
+//	@Summary		Создать API ключ
+//	@Description	Метод создания API ключа. Принимает имя ключа, его пространство имен (namespace) и список прав: segments:read (чтение сегментов), segments:write (изменение сегментов и пользователей), logs:read (чтение истории) и admin (все права и управление ключами). Сам ключ возвращается только в ответе на этот запрос, в базе данных хранится лишь его хеш. Ключ с правом segments:write может изменять только сегменты своего пространства имен, читать можно любые сегменты. Имя ключа записывается в историю изменений как инициатор изменения.
+//	@Tags			keys
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			input	body		request					true	"key name, namespace and scopes"
+//	@Success		201		{object}	response				"created key"
+//	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		409		{object}	handlers.responseError	"error"
+//	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Router			/keys [post]
+func New(service keyCreator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		data := new(request)
+		if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, "invalid data for api key creation")
+			return
+		}
+		defer r.Body.Close()
+		if err := validation.ValidateKeyName(data.Name); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validation.ValidateNamespace(data.Namespace); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validation.ValidateScopes(data.Scopes); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		resp := &response{APIKey: &model.APIKey{
+			Name:      data.Name,
+			Namespace: data.Namespace,
+			Scopes:    data.Scopes,
+		}}
+		var err error
+		resp.Key, err = service.Create(ctx, resp.APIKey)
+		if errors.Is(err, repository.ErrKeyExists) {
+			w.WriteHeader(http.StatusConflict)
+			handlers.WriteJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
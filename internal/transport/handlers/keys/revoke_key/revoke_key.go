@@ -0,0 +1,57 @@
+package revoke_key
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+)
+
+type keyRevoker interface {
+	Revoke(context.Context, uint64) error
+}
+
+// RevokeKey godoc
+//
+//	@Summary		Отозвать API ключ
+//	@Description	Метод отзыва API ключа по его id. Запросы с отозванным ключом отклоняются, записи в истории изменений сохраняют его имя.
+//	@Tags			keys
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path	int	true	"key id"
+//	@Success		200
+//	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		404		{object}	handlers.responseError	"error"
+//	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Router			/keys/{id} [delete]
+func New(service keyRevoker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, "invalid key id")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		err = service.Revoke(ctx, id)
+		if errors.Is(err, repository.ErrKeyNotExists) {
+			w.WriteHeader(http.StatusNotFound)
+			handlers.WriteJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package get_logs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+	"github.com/kiryu-dev/segments-api/internal/transport/validation"
+)
+
+type logsGetter interface {
+	GetLogs(context.Context, *model.LogsFilter) ([]*model.UserLog, error)
+}
+
+type response struct {
+	Logs       []*model.UserLog `json:"logs"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// GetLogs godoc
+//
+//	@Summary		Получить историю изменения сегментов
+//	@Description	Метод получения истории добавления и удаления сегментов в порядке времени изменения с постраничной навигацией. Интервал задается границами from (включительно) и to (не включительно) в формате RFC3339, историю можно отфильтровать по пользователю, сегменту (по текущему названию или названию на момент изменения) и типу операции. Для получения следующей страницы нужно передать next_cursor из предыдущего ответа в параметре cursor.
+//	@Tags			logs
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			from		query		string					false	"start of interval (inclusive)"	Format(date-time)
+//	@Param			to			query		string					false	"end of interval (exclusive)"	Format(date-time)
+//	@Param			user_id		query		int						false	"user id"
+//	@Param			slug		query		string					false	"segment name"
+//	@Param			operation	query		string					false	"operation type"	Enums(add, delete)
+//	@Param			cursor		query		string					false	"next_cursor from previous page"
+//	@Param			limit		query		int						false	"page size (1-1000)"	default(50)
+//	@Success		200			{object}	response				"list of logs"
+//	@Failure		400			{object}	handlers.responseError	"error"
+//	@Failure		401			{object}	handlers.responseError	"error"
+//	@Failure		403			{object}	handlers.responseError	"error"
+//	@Failure		500			{object}	handlers.responseError	"error"
+//	@Failure		default		{object}	handlers.responseError	"error"
+//	@Router			/log [get]
+func New(service logsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		filter, err := getFilter(r.URL.Query())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		logs, err := service.GetLogs(ctx, filter)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		resp := &response{Logs: logs}
+		if len(logs) == filter.Limit {
+			last := logs[len(logs)-1]
+			resp.NextCursor = encodeCursor(&model.LogsCursor{RequestTime: last.RequestTime, ID: last.ID})
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
+
+func getFilter(queries url.Values) (*model.LogsFilter, error) {
+	var (
+		filter = new(model.LogsFilter)
+		err    error
+	)
+	if filter.Limit, err = handlers.ParseLimit(queries); err != nil {
+		return nil, err
+	}
+	if filter.From, err = parseTime(queries, "from"); err != nil {
+		return nil, err
+	}
+	if filter.To, err = parseTime(queries, "to"); err != nil {
+		return nil, err
+	}
+	if filter.From != nil && filter.To != nil && !filter.To.After(*filter.From) {
+		return nil, fmt.Errorf("to should be after from")
+	}
+	if queries.Has("user_id") {
+		userID, err := strconv.ParseUint(queries.Get("user_id"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user id")
+		}
+		filter.UserID = &userID
+	}
+	if queries.Has("slug") {
+		filter.Slug = queries.Get("slug")
+		if err := validation.ValidateSlug(filter.Slug); err != nil {
+			return nil, err
+		}
+	}
+	if queries.Has("operation") {
+		filter.Operation = queries.Get("operation")
+		if filter.Operation != model.AddOp.String() && filter.Operation != model.DeleteOp.String() {
+			return nil, fmt.Errorf("operation must be either %s or %s", model.AddOp, model.DeleteOp)
+		}
+	}
+	if queries.Has("cursor") {
+		if filter.Cursor, err = decodeCursor(queries.Get("cursor")); err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+	}
+	return filter, nil
+}
+
+func parseTime(queries url.Values, key string) (*time.Time, error) {
+	if !queries.Has(key) {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, queries.Get(key))
+	if err != nil {
+		return nil, fmt.Errorf("enter %s in RFC3339 format", key)
+	}
+	return &t, nil
+}
+
+func encodeCursor(cursor *model.LogsCursor) string {
+	raw := fmt.Sprintf("%s,%d", cursor.RequestTime.Format(time.RFC3339Nano), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(value string) (*model.LogsCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	timeStr, idStr, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	cursor := new(model.LogsCursor)
+	if cursor.RequestTime, err = time.Parse(time.RFC3339Nano, timeStr); err != nil {
+		return nil, err
+	}
+	if cursor.ID, err = strconv.ParseUint(idStr, 10, 64); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
@@ -5,32 +5,62 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/model"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+	"github.com/kiryu-dev/segments-api/pkg/util/export"
 )
 
-type logsGetter interface {
-	GetUserLogs(context.Context, uint64, time.Time) (string, error)
+// exportTimeout bounds the time of a single export, which may take
+// much longer than the server's write timeout.
+const exportTimeout = 10 * time.Minute
+
+// exporters are supported export formats, the first one is the default.
+var exporters = []export.Exporter[model.UserLog]{
+	export.CSV[model.UserLog](),
+	export.JSON[model.UserLog](),
+	export.NDJSON[model.UserLog](),
+	export.Parquet[model.UserLog](),
+}
+
+type logsExporter interface {
+	ExportUserLogs(context.Context, uint64, time.Time, export.Writer[model.UserLog]) error
+}
+
+// fileWriter sets file headers right before the first write, so an error
+// that occurs before anything is written can still be returned as JSON.
+type fileWriter struct {
+	http.ResponseWriter
+	contentType string
+	filename    string
+	written     bool
 }
 
 // GetUserLogs godoc
 //
 //	@Summary		Получить историю изменения сегментов пользователя
-//	@Description	Получение истории добавления и удаления сегментов указанного пользователя за определенный год и месяц в формате CSV.
+//	@Description	Получение истории добавления и удаления сегментов указанного пользователя за определенный год и месяц. Формат выгрузки выбирается параметром format или заголовком Accept: CSV с заголовком (по умолчанию), JSON массив, NDJSON или Parquet. Записи передаются по мере чтения из базы данных, без промежуточных файлов.
 //	@Tags			logs
-//	@Produce		octet-stream
+//	@Produce		text/csv
+//	@Produce		json
+//	@Produce		application/x-ndjson
+//	@Produce		application/vnd.apache.parquet
+//	@Security		ApiKeyAuth
 //	@Param			userID	path	int		true	"user id"
 //	@Param			date	query	string	false	"filter date"	Format(year-month)
+//	@Param			format	query	string	false	"export format"	Enums(csv, json, ndjson, parquet)
 //	@Success		200
 //	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		406		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
 //	@Failure		500		{object}	handlers.responseError	"error"
 //	@Failure		default	{object}	handlers.responseError	"error"
 //	@Router			/log/{userID} [get]
-func New(service logsGetter) http.HandlerFunc {
+func New(service logsExporter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		idStr := mux.Vars(r)["userID"]
@@ -46,20 +76,37 @@ func New(service logsGetter) http.HandlerFunc {
 			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-		defer cancel()
-		filename, err := service.GetUserLogs(ctx, userID, filterDate)
+		exporter, err := export.Negotiate(exporters, r.URL.Query().Get("format"), r.Header.Get("Accept"))
 		if err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			handlers.WriteJSONError(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), exportTimeout)
+		defer cancel()
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportTimeout))
+		writer := &fileWriter{
+			ResponseWriter: w,
+			contentType:    exporter.ContentType(),
+			filename: fmt.Sprintf("logs-%d-%s.%s", userID, filterDate.Format("2006-01"),
+				exporter.Extension()),
+		}
+		err = service.ExportUserLogs(ctx, userID, filterDate, exporter.NewWriter(writer))
+		if err != nil && !writer.written {
 			w.WriteHeader(http.StatusInternalServerError)
 			handlers.WriteServerError(w, http.StatusInternalServerError)
-			return
 		}
-		defer os.Remove(filename)
+	}
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		w.written = true
+		w.Header().Set("Content-Type", w.contentType)
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s",
-			strconv.Quote(filename)))
-		w.Header().Set("Content-Type", "application/octet-stream")
-		http.ServeFile(w, r, filename)
+			strconv.Quote(w.filename)))
 	}
+	return w.ResponseWriter.Write(p)
 }
 
 func getFilterDate(queries url.Values) (time.Time, error) {
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 1000
+)
+
+func ParseLimit(queries url.Values) (int, error) {
+	if !queries.Has("limit") {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(queries.Get("limit"))
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, fmt.Errorf("limit must be a number between 1 and %d", maxLimit)
+	}
+	return limit, nil
+}
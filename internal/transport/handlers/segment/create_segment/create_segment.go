@@ -4,44 +4,64 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
 	"github.com/kiryu-dev/segments-api/internal/transport/validation"
 )
 
 type segmentCreator interface {
-	Create(context.Context, string, float64) ([]uint64, error)
+	Create(context.Context, *model.Segment) ([]uint64, error)
+	CreateAsync(context.Context, *model.Segment) (uint64, error)
 }
 
 type request struct {
-	Slug       string  `json:"slug"`
-	Percentage float64 `json:"percentage"`
+	Slug        string             `json:"slug"`
+	Description string             `json:"description"`
+	Tags        []string           `json:"tags"`
+	State       model.SegmentState `json:"state" enums:"draft,active" default:"active"`
+	Percentage  float64            `json:"percentage"`
+	Rollout     model.RolloutMode  `json:"rollout" enums:"random,hash" default:"random"`
+	DefaultTTL  *string            `json:"default_ttl"`
+	Owner       string             `json:"owner"`
+	Async       bool               `json:"async"`
 }
 
 type response struct {
 	Slug    string   `json:"slug"`
 	UsersID []uint64 `json:"users_id"`
+	JobID   uint64   `json:"job_id,omitempty"`
 }
 
 // CreateSegment godoc
 //
 //	@Summary		Создать новый сегмент
-//	@Description	Метод создания сегмента. Принимает slug (название) сегмента. Опционально можно указать процент пользователей, которые добавятся в этот сегмент автоматически.
+//	@Description	Метод создания сегмента. Принимает slug (название) сегмента. Опционально можно указать процент пользователей, которые добавятся в этот сегмент автоматически. В режиме rollout=hash пользователи выбираются детерминированно по хэшу id, а новые пользователи автоматически попадают в сегмент при создании. Можно указать описание (description), список тегов (tags) и состояние (state): draft или active, по умолчанию active. Сегмент в состоянии draft не добавляется пользователям автоматически, пока его не активируют. Можно задать TTL по умолчанию (default_ttl в формате "1y8m21d"), который применяется при каждом добавлении сегмента пользователю без явного TTL. Владельцем сегмента становится пространство имен API ключа, изменять сегмент и его участников могут только ключи этого пространства имен; другого владельца (owner) может указать только ключ с правом admin. С флагом async пользователи добавляются в фоновой задаче: метод сразу возвращает id задачи, ее прогресс можно узнать через GET /jobs/{id}.
 //	@Tags			segment
 //	@Accept			json
 //	@Produce		json
-//	@Param			input	body		request					true	"segment name and user percentage (optional)"
+//	@Security		ApiKeyAuth
+//	@Param			Idempotency-Key	header		string					false	"retries with the same key within idempotency window replay the first response"
+//	@Param			input	body		request					true	"segment name, metadata, user percentage, rollout mode, default ttl and async flag (optional)"
 //	@Success		200		{object}	response				"(optional) segment name and added users"
+//	@Success		202		{object}	response				"segment name and id of the job adding users"
 //	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		409		{object}	handlers.responseError	"error"
+//	@Failure		422		{object}	handlers.responseError	"error"
 //	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		503		{object}	handlers.responseError	"error"
 //	@Failure		default	{object}	handlers.responseError	"error"
 //	@Router			/segment [post]
 func New(service segmentCreator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		data := new(request)
+		data := &request{Rollout: model.RandomRollout, State: model.ActiveState}
 		if err := json.NewDecoder(r.Body).Decode(data); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			handlers.WriteJSONError(w, http.StatusBadRequest, "invalid data for segment creation")
@@ -64,10 +84,60 @@ func New(service segmentCreator) http.HandlerFunc {
 			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		if err := validateMetadata(data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validation.ValidateNamespace(data.Owner); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validation.ValidateRollout(data.Rollout); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defaultTTL, err := getDefaultTTL(data.DefaultTTL)
+		if errors.Is(err, validation.ErrRegexpErr) {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
+		seg := &model.Segment{
+			Slug:        data.Slug,
+			Description: data.Description,
+			Tags:        data.Tags,
+			State:       data.State,
+			Percentage:  data.Percentage,
+			Rollout:     data.Rollout,
+			DefaultTTL:  defaultTTL,
+			Owner:       data.Owner,
+		}
+		if data.Async && data.Percentage > 0 && data.State != model.DraftState {
+			createAsync(ctx, w, service, seg)
+			return
+		}
 		resp := &response{Slug: data.Slug}
-		resp.UsersID, err = service.Create(ctx, data.Slug, data.Percentage)
+		resp.UsersID, err = service.Create(ctx, seg)
+		if errors.Is(err, repository.ErrForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+			handlers.WriteJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, repository.ErrSegmentExists) {
+			w.WriteHeader(http.StatusConflict)
+			handlers.WriteJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			handlers.WriteServerError(w, http.StatusInternalServerError)
@@ -79,3 +149,57 @@ func New(service segmentCreator) http.HandlerFunc {
 		}
 	}
 }
+
+func validateMetadata(data *request) error {
+	if err := validation.ValidateDescription(data.Description); err != nil {
+		return err
+	}
+	if err := validation.ValidateTags(data.Tags); err != nil {
+		return err
+	}
+	// users are added to the segment right after creation, so it can't be archived
+	if data.State == model.ArchivedState {
+		return fmt.Errorf("segment can't be created in %q state", model.ArchivedState)
+	}
+	return validation.ValidateState(data.State)
+}
+
+func getDefaultTTL(ttl *string) (string, error) {
+	if ttl == nil {
+		return "", nil
+	}
+	parsed, err := validation.ValidateTTL(*ttl)
+	if err != nil {
+		return "", err
+	}
+	return parsed.String(), nil
+}
+
+func createAsync(ctx context.Context, w http.ResponseWriter, service segmentCreator, seg *model.Segment) {
+	jobID, err := service.CreateAsync(ctx, seg)
+	if errors.Is(err, repository.ErrForbidden) {
+		w.WriteHeader(http.StatusForbidden)
+		handlers.WriteJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, repository.ErrSegmentExists) {
+		w.WriteHeader(http.StatusConflict)
+		handlers.WriteJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, repository.ErrJobQueueFull) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		handlers.WriteJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		handlers.WriteServerError(w, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(&response{
+		Slug:  seg.Slug,
+		JobID: jobID,
+	})
+}
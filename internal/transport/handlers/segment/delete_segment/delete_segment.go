@@ -2,8 +2,10 @@ package delete_segment
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -14,18 +16,29 @@ import (
 
 type segmentDeleter interface {
 	Delete(context.Context, string) error
+	DeleteAsync(context.Context, string) (uint64, error)
+}
+
+type asyncResponse struct {
+	JobID uint64 `json:"job_id"`
 }
 
 // DeleteSegment godoc
 //
 //	@Summary		Удалить сегмент
-//	@Description	Метод удаления сегмента. Принимает slug (название) сегмента.
+//	@Description	Метод удаления сегмента. Принимает slug (название) сегмента. Сегмент вместе с составом участников можно восстановить через POST /segment/{slug}/restore в течение времени хранения удаленных сегментов, после чего он удаляется окончательно. Удалить сегмент может только ключ из пространства имен его владельца или ключ с правом admin. С параметром async=true сегмент удаляется в фоновой задаче: метод сразу возвращает id задачи, ее прогресс можно узнать через GET /jobs/{id}.
 //	@Tags			segment
 //	@Produce		json
+//	@Security		ApiKeyAuth
 //	@Param			slug	path	string	true	"segment name"
+//	@Param			async	query	bool	false	"delete in background job"
 //	@Success		200
+//	@Success		202		{object}	asyncResponse			"id of the job deleting segment"
 //	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
 //	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		503		{object}	handlers.responseError	"error"
 //	@Failure		default	{object}	handlers.responseError	"error"
 //	@Router			/segment/{slug} [delete]
 func New(service segmentDeleter) http.HandlerFunc {
@@ -43,9 +56,24 @@ func New(service segmentDeleter) http.HandlerFunc {
 			handlers.WriteServerError(w, http.StatusInternalServerError)
 			return
 		}
+		async, err := strconv.ParseBool(r.URL.Query().Get("async"))
+		if err != nil && r.URL.Query().Has("async") {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, "async must be either true or false")
+			return
+		}
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
+		if async {
+			deleteAsync(ctx, w, service, slug)
+			return
+		}
 		err = service.Delete(ctx, slug)
+		if errors.Is(err, repository.ErrForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+			handlers.WriteJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
 		if errors.Is(err, repository.ErrSegmentNotExists) {
 			w.WriteHeader(http.StatusBadRequest)
 			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
@@ -57,3 +85,29 @@ func New(service segmentDeleter) http.HandlerFunc {
 		}
 	}
 }
+
+func deleteAsync(ctx context.Context, w http.ResponseWriter, service segmentDeleter, slug string) {
+	jobID, err := service.DeleteAsync(ctx, slug)
+	if errors.Is(err, repository.ErrForbidden) {
+		w.WriteHeader(http.StatusForbidden)
+		handlers.WriteJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, repository.ErrSegmentNotExists) {
+		w.WriteHeader(http.StatusBadRequest)
+		handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, repository.ErrJobQueueFull) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		handlers.WriteJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		handlers.WriteServerError(w, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(&asyncResponse{jobID})
+}
@@ -0,0 +1,70 @@
+package get_segment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+	"github.com/kiryu-dev/segments-api/internal/transport/validation"
+)
+
+type segmentGetter interface {
+	Get(context.Context, string) (*model.SegmentStats, error)
+}
+
+// GetSegment godoc
+//
+//	@Summary		Получить информацию о сегменте
+//	@Description	Метод получения информации о сегменте: время создания, процент пользователей и режим их выбора, количество участников, количество участников с TTL, ближайшее и самое позднее время истечения TTL. Принимает slug (название) сегмента.
+//	@Tags			segment
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			slug	path		string					true	"segment name"
+//	@Success		200		{object}	model.SegmentStats		"segment info"
+//	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		404		{object}	handlers.responseError	"error"
+//	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Router			/segment/{slug} [get]
+func New(service segmentGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		slug := mux.Vars(r)["slug"]
+		err := validation.ValidateSlug(slug)
+		if errors.Is(err, validation.ErrInvalidChar) || errors.Is(err, validation.ErrInvalidSize) {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		segment, err := service.Get(ctx, slug)
+		if errors.Is(err, repository.ErrSegmentNotExists) {
+			w.WriteHeader(http.StatusNotFound)
+			handlers.WriteJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(segment); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package get_segment_users
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+	"github.com/kiryu-dev/segments-api/internal/transport/validation"
+)
+
+type membersGetter interface {
+	GetMembers(context.Context, string, *model.MembersFilter) ([]*model.UserSegment, error)
+}
+
+type response struct {
+	Users      []*model.UserSegment `json:"users"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// GetSegmentUsers godoc
+//
+//	@Summary		Получить участников сегмента
+//	@Description	Метод получения пользователей, состоящих в сегменте, в порядке возрастания id с постраничной навигацией. Для получения следующей страницы нужно передать next_cursor из предыдущего ответа (или заголовка X-Next-Cursor для CSV) в параметре cursor. Можно оставить только пользователей с TTL (или без него) и пользователей, у которых сегмент истекает раньше указанного времени.
+//	@Tags			segment
+//	@Produce		json
+//	@Produce		text/csv
+//	@Security		ApiKeyAuth
+//	@Param			slug			path		string					true	"segment name"
+//	@Param			cursor			query		int						false	"next_cursor from previous page"
+//	@Param			limit			query		int						false	"page size (1-1000)"	default(50)
+//	@Param			has_ttl			query		bool					false	"only users with (true) or without (false) ttl"
+//	@Param			expires_before	query		string					false	"only users whose ttl expires before"	Format(date-time)
+//	@Param			format			query		string					false	"response format"	Enums(json, csv)	default(json)
+//	@Success		200				{object}	response				"list of users"
+//	@Failure		400				{object}	handlers.responseError	"error"
+//	@Failure		401				{object}	handlers.responseError	"error"
+//	@Failure		403				{object}	handlers.responseError	"error"
+//	@Failure		404				{object}	handlers.responseError	"error"
+//	@Failure		500				{object}	handlers.responseError	"error"
+//	@Failure		default			{object}	handlers.responseError	"error"
+//	@Router			/segment/{slug}/users [get]
+func New(service membersGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		slug := mux.Vars(r)["slug"]
+		err := validation.ValidateSlug(slug)
+		if errors.Is(err, validation.ErrInvalidChar) || errors.Is(err, validation.ErrInvalidSize) {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		queries := r.URL.Query()
+		filter, err := getFilter(queries)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		format := queries.Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, "format must be either json or csv")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		members, err := service.GetMembers(ctx, slug, filter)
+		if errors.Is(err, repository.ErrSegmentNotExists) {
+			w.WriteHeader(http.StatusNotFound)
+			handlers.WriteJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		resp := &response{Users: members}
+		if len(members) == filter.Limit {
+			resp.NextCursor = strconv.FormatUint(members[len(members)-1].UserID, 10)
+			w.Header().Set("X-Next-Cursor", resp.NextCursor)
+		}
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			// the status is already sent, so a failed write can only be reported
+			if err := writeCSV(w, members); err != nil {
+				log.Printf("cannot write members of segment %s as csv: %v", slug, err)
+			}
+			return
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
+
+func getFilter(queries url.Values) (*model.MembersFilter, error) {
+	var (
+		filter = new(model.MembersFilter)
+		err    error
+	)
+	if filter.Limit, err = handlers.ParseLimit(queries); err != nil {
+		return nil, err
+	}
+	if queries.Has("cursor") {
+		if filter.Cursor, err = strconv.ParseUint(queries.Get("cursor"), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+	}
+	if queries.Has("has_ttl") {
+		hasTTL, err := strconv.ParseBool(queries.Get("has_ttl"))
+		if err != nil {
+			return nil, fmt.Errorf("has_ttl must be either true or false")
+		}
+		filter.HasTTL = &hasTTL
+	}
+	if queries.Has("expires_before") {
+		expiresBefore, err := time.Parse(time.RFC3339, queries.Get("expires_before"))
+		if err != nil {
+			return nil, fmt.Errorf("enter expires_before in RFC3339 format")
+		}
+		filter.ExpiresBefore = &expiresBefore
+	}
+	return filter, nil
+}
+
+func writeCSV(w http.ResponseWriter, members []*model.UserSegment) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"user_id", "delete_time", "assign_time"}); err != nil {
+		return err
+	}
+	for _, member := range members {
+		record := []string{
+			strconv.FormatUint(member.UserID, 10),
+			formatTime(member.DeleteTime),
+			formatTime(member.AssignTime),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,66 @@
+package get_segments
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+)
+
+type segmentsLister interface {
+	List(context.Context, string, string, int) ([]*model.SegmentStats, error)
+}
+
+type response struct {
+	Segments   []*model.SegmentStats `json:"segments"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+// GetSegments godoc
+//
+//	@Summary		Получить список сегментов
+//	@Description	Метод получения списка сегментов в алфавитном порядке с постраничной навигацией. Для каждого сегмента возвращаются время создания, процент пользователей, количество участников и статистика по TTL. Для получения следующей страницы нужно передать next_cursor из предыдущего ответа в параметре cursor.
+//	@Tags			segment
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			prefix	query		string					false	"segment name prefix"
+//	@Param			cursor	query		string					false	"next_cursor from previous page"
+//	@Param			limit	query		int						false	"page size (1-1000)"	default(50)
+//	@Success		200		{object}	response				"list of segments"
+//	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Router			/segment [get]
+func New(service segmentsLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		queries := r.URL.Query()
+		limit, err := handlers.ParseLimit(queries)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		segments, err := service.List(ctx, queries.Get("prefix"), queries.Get("cursor"), limit)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		resp := &response{Segments: segments}
+		if len(segments) == limit {
+			resp.NextCursor = segments[len(segments)-1].Slug
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package restore_segment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+	"github.com/kiryu-dev/segments-api/internal/transport/validation"
+)
+
+type segmentRestorer interface {
+	Restore(context.Context, string) ([]uint64, error)
+}
+
+type response struct {
+	Slug    string   `json:"slug"`
+	UsersID []uint64 `json:"users_id"`
+}
+
+// RestoreSegment godoc
+//
+//	@Summary		Восстановить сегмент
+//	@Description	Метод восстановления удаленного сегмента. Принимает slug (название) сегмента. Сегмент возвращается всем пользователям, которые состояли в нем на момент удаления, если их TTL не истек, а сами пользователи не удалены. Восстановить сегмент можно в течение времени хранения удаленных сегментов, после чего они удаляются окончательно. Название удаленного сегмента можно сразу использовать для нового сегмента, восстанавливается последний удаленный сегмент с этим названием; если название уже занято, восстановить его нельзя.
+//	@Tags			segment
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			slug	path		string					true	"segment name"
+//	@Success		200		{object}	response				"users the segment is restored to"
+//	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		404		{object}	handlers.responseError	"error"
+//	@Failure		409		{object}	handlers.responseError	"error"
+//	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Router			/segment/{slug}/restore [post]
+func New(service segmentRestorer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		slug := mux.Vars(r)["slug"]
+		err := validation.ValidateSlug(slug)
+		if errors.Is(err, validation.ErrInvalidChar) || errors.Is(err, validation.ErrInvalidSize) {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		users, err := service.Restore(ctx, slug)
+		if errors.Is(err, repository.ErrForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+			handlers.WriteJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, repository.ErrSegmentNotExists) || errors.Is(err, repository.ErrNotDeleted) {
+			w.WriteHeader(http.StatusNotFound)
+			handlers.WriteJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, repository.ErrSlugTaken) {
+			w.WriteHeader(http.StatusConflict)
+			handlers.WriteJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(&response{slug, users}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
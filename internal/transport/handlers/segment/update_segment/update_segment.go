@@ -0,0 +1,149 @@
+package update_segment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+	"github.com/kiryu-dev/segments-api/internal/transport/validation"
+)
+
+type segmentUpdater interface {
+	Update(context.Context, string, *model.SegmentUpdate) (*model.SegmentStats, uint64, error)
+}
+
+type request struct {
+	Slug        *string             `json:"slug"`
+	Description *string             `json:"description"`
+	Tags        *[]string           `json:"tags"`
+	State       *model.SegmentState `json:"state" enums:"draft,active,archived"`
+	Owner       *string             `json:"owner"`
+}
+
+type response struct {
+	*model.SegmentStats
+	JobID uint64 `json:"job_id,omitempty"`
+}
+
+// UpdateSegment godoc
+//
+//	@Summary		Изменить сегмент
+//	@Description	Метод изменения сегмента. Принимает текущий slug (название) сегмента и в body изменяемые поля: новое название, описание (description), список тегов (tags), состояние (state) и владельца (owner); не указанные поля не меняются. При переименовании участники сегмента и их TTL сохраняются, записи истории продолжают относиться к сегменту: в них возвращается как текущее название сегмента (slug), так и название на момент изменения (original_slug). При активации сегмента в состоянии draft он добавляется заданному проценту пользователей в фоновой задаче: метод возвращает id задачи, ее прогресс можно узнать через GET /jobs/{id}; вернуть активированный сегмент в draft нельзя. Сегмент в состоянии archived нельзя добавить пользователям, но его текущие участники и история сохраняются. Передать сегмент другому пространству имен может только ключ с правом admin.
+//	@Tags			segment
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			slug	path		string					true	"segment name"
+//	@Param			input	body		request					true	"fields to change"
+//	@Success		200		{object}	response				"updated segment"
+//	@Success		202		{object}	response				"activated segment and id of the job adding users"
+//	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		404		{object}	handlers.responseError	"error"
+//	@Failure		409		{object}	handlers.responseError	"error"
+//	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		503		{object}	handlers.responseError	"error"
+//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Router			/segment/{slug} [patch]
+func New(service segmentUpdater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		slug := mux.Vars(r)["slug"]
+		data := new(request)
+		if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, "invalid data to update segment")
+			return
+		}
+		defer r.Body.Close()
+		err := validate(slug, data)
+		if errors.Is(err, validation.ErrRegexpErr) {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		segment, jobID, err := service.Update(ctx, slug, &model.SegmentUpdate{
+			Slug:        data.Slug,
+			Description: data.Description,
+			Tags:        data.Tags,
+			State:       data.State,
+			Owner:       data.Owner,
+		})
+		if errors.Is(err, repository.ErrForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+			handlers.WriteJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, repository.ErrSegmentNotExists) {
+			w.WriteHeader(http.StatusNotFound)
+			handlers.WriteJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, repository.ErrSegmentExists) || errors.Is(err, repository.ErrSegmentActivated) {
+			w.WriteHeader(http.StatusConflict)
+			handlers.WriteJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, repository.ErrJobQueueFull) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			handlers.WriteJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		if jobID != 0 {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		if err := json.NewEncoder(w).Encode(&response{segment, jobID}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
+
+func validate(slug string, data *request) error {
+	if err := validation.ValidateSlug(slug); err != nil {
+		return err
+	}
+	if data.Slug != nil {
+		if err := validation.ValidateSlug(*data.Slug); err != nil {
+			return err
+		}
+	}
+	if data.Description != nil {
+		if err := validation.ValidateDescription(*data.Description); err != nil {
+			return err
+		}
+	}
+	if data.Tags != nil {
+		if err := validation.ValidateTags(*data.Tags); err != nil {
+			return err
+		}
+	}
+	if data.State != nil {
+		if err := validation.ValidateState(*data.State); err != nil {
+			return err
+		}
+	}
+	if data.Owner != nil {
+		return validation.ValidateNamespace(*data.Owner)
+	}
+	return nil
+}
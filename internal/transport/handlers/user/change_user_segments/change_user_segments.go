@@ -15,19 +15,24 @@ import (
 
 type segmentChanger interface {
 	Change(context.Context, []*model.UserSegment, model.OpType) []error
+	ChangeAtomic(context.Context, []*model.UserSegment, []*model.UserSegment) ([]error, []error)
 }
 
 type segmentWithTTL struct {
-	Slug string  `json:"slug"`
-	TTL  *string `json:"ttl"`
+	Slug      string     `json:"slug"`
+	TTL       *string    `json:"ttl"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	StartsAt  *time.Time `json:"starts_at"`
 }
 
 type segments []*segmentWithTTL
 
 type request struct {
-	UserID   uint64   `json:"user_id"`
-	ToAdd    segments `json:"to_add"`
-	ToDelete []string `json:"to_delete"`
+	UserID     uint64               `json:"user_id"`
+	ToAdd      segments             `json:"to_add"`
+	ToDelete   []string             `json:"to_delete"`
+	Atomic     bool                 `json:"atomic"`
+	OnConflict model.ConflictPolicy `json:"on_conflict" enums:"error,ignore,refresh" default:"error"`
 }
 
 type response struct {
@@ -40,13 +45,19 @@ type response struct {
 // ChangeUserSegments godoc
 //
 //	@Summary		Изменить сегменты пользователя
-//	@Description	Метод изменения активных сегментов пользователя. Принимает список slug (названий) сегментов которые нужно добавить пользователю, список slug (названий) сегментов которые нужно удалить у пользователя, id пользователя. Также есть возможность задать TTL для добавляемых сегментов, чтобы по истечению времени они автоматически удалились у пользователя. TTL задается в формате "1y8m21d". Если хотите только удалить определенные сегменты, то можно опустить список для добавления и наоборот.
+//	@Description	Метод изменения активных сегментов пользователя. Принимает список slug (названий) сегментов которые нужно добавить пользователю, список slug (названий) сегментов которые нужно удалить у пользователя, id пользователя. Также есть возможность задать TTL для добавляемых сегментов, чтобы по истечению времени они автоматически удалились у пользователя. TTL задается в формате "1y8m21d", вместо него можно указать точное время истечения expires_at в формате RFC3339. Если TTL не указан, применяется TTL сегмента по умолчанию (если он задан). Добавление сегмента можно запланировать на будущее, указав время начала starts_at в формате RFC3339: до этого момента сегмент не возвращается в списке сегментов пользователя, а TTL отсчитывается от времени начала. Если хотите только удалить определенные сегменты, то можно опустить список для добавления и наоборот. С флагом atomic все изменения применяются в одной транзакции: при ошибке хотя бы одного изменения ни одно из них не применяется. Параметр on_conflict задает поведение при добавлении сегмента, который у пользователя уже есть: error (по умолчанию) возвращает ошибку, ignore оставляет сегмент без изменений, refresh заменяет время его удаления на заданное в запросе TTL (или TTL сегмента по умолчанию); в истории такое добавление не записывается. Сегменты в состоянии archived добавить нельзя, но их можно удалить у пользователя.
 //	@Tags			user
 //	@Accept			json
 //	@Produce		json
-//	@Param			input	body		request					true	"user id, segment's list to add (with ttl optional), segment's list to delete"
+//	@Security		ApiKeyAuth
+//	@Param			Idempotency-Key	header		string					false	"retries with the same key within idempotency window replay the first response"
+//	@Param			input	body		request					true	"user id, segment's list to add (with ttl optional), segment's list to delete, atomic flag and conflict policy (optional)"
 //	@Success		200		{object}	response				"list of changes"
 //	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		409		{object}	handlers.responseError	"error"
+//	@Failure		422		{object}	handlers.responseError	"error"
 //	@Failure		500		{object}	handlers.responseError	"error"
 //	@Failure		default	{object}	handlers.responseError	"error"
 //	@Router			/user-segments [post]
@@ -54,7 +65,7 @@ func New(service segmentChanger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		var (
-			data = new(request)
+			data = &request{OnConflict: model.ConflictError}
 			err  = json.NewDecoder(r.Body).Decode(data)
 		)
 		defer r.Body.Close()
@@ -63,9 +74,14 @@ func New(service segmentChanger) http.HandlerFunc {
 			handlers.WriteJSONError(w, http.StatusBadRequest, "invalid data to change user's segments")
 			return
 		}
+		if err := validation.ValidateConflictPolicy(data.OnConflict); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
-		addSeg, err := data.ToAdd.toSegmentModel(data.UserID)
+		addSeg, err := data.ToAdd.toSegmentModel(data.UserID, data.OnConflict)
 		if errors.Is(err, validation.ErrRegexpErr) {
 			w.WriteHeader(http.StatusInternalServerError)
 			handlers.WriteServerError(w, http.StatusInternalServerError)
@@ -76,10 +92,10 @@ func New(service segmentChanger) http.HandlerFunc {
 			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		addErr, delErr := changeSegments(ctx, service, data.Atomic, addSeg,
+			slugsToSegment(data.UserID, data.ToDelete))
 		var (
-			addErr = service.Change(ctx, addSeg, model.AddOp)
 			offset = len(addErr)
-			delErr = service.Change(ctx, slugsToSegment(data.UserID, data.ToDelete), model.DeleteOp)
 			resp   = make([]*response, offset+len(delErr))
 		)
 		for i, err := range addErr {
@@ -95,6 +111,14 @@ func New(service segmentChanger) http.HandlerFunc {
 	}
 }
 
+func changeSegments(ctx context.Context, service segmentChanger, atomic bool,
+	toAdd, toDelete []*model.UserSegment) (addErr, delErr []error) {
+	if atomic {
+		return service.ChangeAtomic(ctx, toAdd, toDelete)
+	}
+	return service.Change(ctx, toAdd, model.AddOp), service.Change(ctx, toDelete, model.DeleteOp)
+}
+
 func createResponse(err error, slug string, op model.OpType) *response {
 	resp := &response{
 		Slug:       slug,
@@ -102,9 +126,16 @@ func createResponse(err error, slug string, op model.OpType) *response {
 		OpType:     op.String(),
 	}
 	if errors.Is(err, repository.ErrSegmentNotExists) ||
+		errors.Is(err, repository.ErrSegmentArchived) ||
 		errors.Is(err, repository.ErrHasSegment) {
 		resp.StatusCode = http.StatusBadRequest
 		resp.Message = err.Error()
+	} else if errors.Is(err, repository.ErrForbidden) {
+		resp.StatusCode = http.StatusForbidden
+		resp.Message = err.Error()
+	} else if errors.Is(err, repository.ErrRolledBack) {
+		resp.StatusCode = http.StatusConflict
+		resp.Message = err.Error()
 	} else if err != nil {
 		resp.StatusCode = http.StatusInternalServerError
 	}
@@ -122,22 +153,20 @@ func slugsToSegment(id uint64, slugs []string) []*model.UserSegment {
 	return result
 }
 
-func (s segments) toSegmentModel(userID uint64) ([]*model.UserSegment, error) {
+func (s segments) toSegmentModel(userID uint64, onConflict model.ConflictPolicy) ([]*model.UserSegment, error) {
 	result := make([]*model.UserSegment, len(s))
 	for i, seg := range s {
-		result[i] = &model.UserSegment{
-			UserID: userID,
-			Slug:   seg.Slug,
-		}
-		if seg.TTL == nil {
-			continue
-		}
-		ttl, err := validation.ValidateTTL(*seg.TTL)
+		deleteTime, err := validation.ValidateSchedule(seg.StartsAt, seg.TTL, seg.ExpiresAt)
 		if err != nil {
 			return nil, err
 		}
-		deleteTime := time.Now().AddDate(ttl.Years, ttl.Months, ttl.Days)
-		result[i].DeleteTime = &deleteTime
+		result[i] = &model.UserSegment{
+			UserID:     userID,
+			Slug:       seg.Slug,
+			DeleteTime: deleteTime,
+			StartTime:  seg.StartsAt,
+			OnConflict: onConflict,
+		}
 	}
 	return result, nil
 }
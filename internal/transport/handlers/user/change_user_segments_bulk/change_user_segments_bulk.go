@@ -0,0 +1,288 @@
+package change_user_segments_bulk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+	"github.com/kiryu-dev/segments-api/internal/transport/validation"
+	"github.com/kiryu-dev/segments-api/pkg/util/parser"
+)
+
+const (
+	maxUsers      = 100000
+	maxMemorySize = 32 << 20
+)
+
+type bulkChanger interface {
+	ChangeBulk(context.Context, []uint64, []*model.BulkChange) []*model.BulkResult
+	ChangeBulkAsync(context.Context, []uint64, []*model.BulkChange) (uint64, error)
+}
+
+type segmentWithTTL struct {
+	Slug      string     `json:"slug"`
+	TTL       *string    `json:"ttl"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type request struct {
+	UserIDs  []uint64          `json:"user_ids"`
+	ToAdd    []*segmentWithTTL `json:"to_add"`
+	ToDelete []string          `json:"to_delete"`
+	Async    bool              `json:"async"`
+}
+
+type segmentResponse struct {
+	Slug       string `json:"slug"`
+	OpType     string `json:"operation_type"`
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message"`
+	Affected   int    `json:"affected"`
+}
+
+type userResponse struct {
+	UserID  uint64   `json:"user_id"`
+	Added   []string `json:"added"`
+	Deleted []string `json:"deleted"`
+	Skipped []string `json:"skipped"`
+}
+
+type response struct {
+	Segments []*segmentResponse `json:"segments"`
+	Users    []*userResponse    `json:"users"`
+}
+
+type asyncResponse struct {
+	JobID uint64 `json:"job_id"`
+}
+
+// ChangeUserSegmentsBulk godoc
+//
+//	@Summary		Изменить сегменты множества пользователей
+//	@Description	Метод добавления и удаления сегментов сразу у множества пользователей (до 100000 за запрос). Принимает JSON со списком id пользователей, списком сегментов для добавления (с TTL или временем истечения expires_at опционально) и списком сегментов для удаления. Также можно отправить multipart/form-data с CSV файлом users (id пользователей в первом столбце) и полями to_add и to_delete в формате JSON. В ответе для каждого сегмента указывается результат и количество затронутых пользователей, а для каждого пользователя — добавленные, удаленные и пропущенные (пользователь уже состоял или не состоял в сегменте, либо не существует) сегменты. С флагом async изменения применяются в фоновой задаче: метод сразу возвращает id задачи, ее прогресс можно узнать через GET /jobs/{id}.
+//	@Tags			user
+//	@Accept			json
+//	@Accept			mpfd
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			input	body		request					true	"user ids, segment's list to add (with ttl optional), segment's list to delete, async flag (optional)"
+//	@Success		200		{object}	response				"summary of changes"
+//	@Success		202		{object}	asyncResponse			"id of the job applying changes"
+//	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		503		{object}	handlers.responseError	"error"
+//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Router			/user-segments/bulk [post]
+func New(service bulkChanger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		data, err := parseRequest(r)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		changes, err := data.toChanges()
+		if errors.Is(err, validation.ErrRegexpErr) {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		userIDs := unique(data.UserIDs)
+		if data.Async {
+			changeAsync(ctx, w, service, userIDs, changes)
+			return
+		}
+		resp := createResponse(userIDs, service.ChangeBulk(ctx, userIDs, changes))
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
+
+func changeAsync(ctx context.Context, w http.ResponseWriter, service bulkChanger,
+	userIDs []uint64, changes []*model.BulkChange) {
+	jobID, err := service.ChangeBulkAsync(ctx, userIDs, changes)
+	if errors.Is(err, repository.ErrJobQueueFull) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		handlers.WriteJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		handlers.WriteServerError(w, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(&asyncResponse{jobID})
+}
+
+func parseRequest(r *http.Request) (*request, error) {
+	var (
+		data   = new(request)
+		err    error
+		invErr = fmt.Errorf("invalid data to change users' segments")
+	)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		data, err = parseMultipart(r)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+		return nil, invErr
+	}
+	if len(data.UserIDs) == 0 || len(data.ToAdd) == 0 && len(data.ToDelete) == 0 {
+		return nil, invErr
+	}
+	if len(data.UserIDs) > maxUsers {
+		return nil, fmt.Errorf("too many users: at most %d are allowed", maxUsers)
+	}
+	return data, nil
+}
+
+func parseMultipart(r *http.Request) (*request, error) {
+	if err := r.ParseMultipartForm(maxMemorySize); err != nil {
+		return nil, fmt.Errorf("invalid multipart form")
+	}
+	file, _, err := r.FormFile("users")
+	if err != nil {
+		return nil, fmt.Errorf("csv file with user ids is expected in users field")
+	}
+	defer file.Close()
+	data := new(request)
+	if data.UserIDs, err = parser.ParseIDs(file); err != nil {
+		return nil, err
+	}
+	if value := r.FormValue("to_add"); value != "" {
+		if err := json.Unmarshal([]byte(value), &data.ToAdd); err != nil {
+			return nil, fmt.Errorf("invalid list of segments to add")
+		}
+	}
+	if value := r.FormValue("to_delete"); value != "" {
+		if err := json.Unmarshal([]byte(value), &data.ToDelete); err != nil {
+			return nil, fmt.Errorf("invalid list of segments to delete")
+		}
+	}
+	if value := r.FormValue("async"); value != "" {
+		if data.Async, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("async must be either true or false")
+		}
+	}
+	return data, nil
+}
+
+func (r *request) toChanges() ([]*model.BulkChange, error) {
+	changes := make([]*model.BulkChange, 0, len(r.ToAdd)+len(r.ToDelete))
+	for _, seg := range r.ToAdd {
+		if err := validation.ValidateSlug(seg.Slug); err != nil {
+			return nil, err
+		}
+		deleteTime, err := validation.ValidateExpiry(seg.TTL, seg.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &model.BulkChange{
+			Slug:       seg.Slug,
+			Operation:  model.AddOp,
+			DeleteTime: deleteTime,
+		})
+	}
+	for _, slug := range r.ToDelete {
+		if err := validation.ValidateSlug(slug); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &model.BulkChange{
+			Slug:      slug,
+			Operation: model.DeleteOp,
+		})
+	}
+	return changes, nil
+}
+
+func createResponse(userIDs []uint64, results []*model.BulkResult) *response {
+	var (
+		resp = &response{
+			Segments: make([]*segmentResponse, len(results)),
+			Users:    make([]*userResponse, len(userIDs)),
+		}
+		changed = make([]map[uint64]struct{}, len(results))
+	)
+	for i, result := range results {
+		resp.Segments[i] = &segmentResponse{
+			Slug:       result.Change.Slug,
+			OpType:     result.Change.Operation.String(),
+			StatusCode: http.StatusOK,
+			Affected:   len(result.Users),
+		}
+		if errors.Is(result.Err, repository.ErrSegmentNotExists) ||
+			errors.Is(result.Err, repository.ErrSegmentArchived) {
+			resp.Segments[i].StatusCode = http.StatusBadRequest
+			resp.Segments[i].Message = result.Err.Error()
+		} else if errors.Is(result.Err, repository.ErrForbidden) {
+			resp.Segments[i].StatusCode = http.StatusForbidden
+			resp.Segments[i].Message = result.Err.Error()
+		} else if result.Err != nil {
+			resp.Segments[i].StatusCode = http.StatusInternalServerError
+		}
+		changed[i] = make(map[uint64]struct{}, len(result.Users))
+		for _, id := range result.Users {
+			changed[i][id] = struct{}{}
+		}
+	}
+	for i, id := range userIDs {
+		user := &userResponse{
+			UserID:  id,
+			Added:   make([]string, 0),
+			Deleted: make([]string, 0),
+			Skipped: make([]string, 0),
+		}
+		for j, result := range results {
+			if result.Err != nil {
+				continue
+			}
+			if _, ok := changed[j][id]; !ok {
+				user.Skipped = append(user.Skipped, result.Change.Slug)
+			} else if result.Change.Operation == model.AddOp {
+				user.Added = append(user.Added, result.Change.Slug)
+			} else {
+				user.Deleted = append(user.Deleted, result.Change.Slug)
+			}
+		}
+		resp.Users[i] = user
+	}
+	return resp
+}
+
+func unique(ids []uint64) []uint64 {
+	var (
+		seen   = make(map[uint64]struct{}, len(ids))
+		result = make([]uint64, 0, len(ids))
+	)
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			result = append(result, id)
+		}
+	}
+	return result
+}
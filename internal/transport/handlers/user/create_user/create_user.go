@@ -3,30 +3,43 @@ package create_user
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/kiryu-dev/segments-api/internal/repository"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
 )
 
 type userCreator interface {
-	Create(context.Context, uint64) error
+	Create(context.Context, uint64) ([]string, error)
 }
 
 type request struct {
 	UserID uint64 `json:"user_id"`
 }
 
+type response struct {
+	UserID   uint64   `json:"user_id"`
+	Segments []string `json:"segments"`
+}
+
 // CreateUser godoc
 //
 //	@Summary		Создать нового пользователя
-//	@Description	Метод создания пользователя. Принимает на вход id пользователя.
+//	@Description	Метод создания пользователя. Принимает на вход id пользователя. Пользователь автоматически добавляется в сегменты, созданные с процентом пользователей, если попадает в выборку.
 //	@Tags			user
 //	@Accept			json
 //	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			Idempotency-Key	header		string					false	"retries with the same key within idempotency window replay the first response"
 //	@Param			input	body	request	true	"user id"
-//	@Success		200
+//	@Success		200		{object}	response				"user id and segments the user was added to"
 //	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		409		{object}	handlers.responseError	"error"
+//	@Failure		422		{object}	handlers.responseError	"error"
 //	@Failure		500		{object}	handlers.responseError	"error"
 //	@Failure		default	{object}	handlers.responseError	"error"
 //	@Router			/user [post]
@@ -40,9 +53,24 @@ func New(service userCreator) http.HandlerFunc {
 			return
 		}
 		defer r.Body.Close()
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
-		if err := service.Create(ctx, data.UserID); err != nil {
+		segments, err := service.Create(ctx, data.UserID)
+		if errors.Is(err, repository.ErrUserExists) {
+			w.WriteHeader(http.StatusConflict)
+			handlers.WriteJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		resp := &response{
+			UserID:   data.UserID,
+			Segments: segments,
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			handlers.WriteServerError(w, http.StatusInternalServerError)
 		}
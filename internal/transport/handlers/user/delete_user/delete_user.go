@@ -22,9 +22,12 @@ type userDeleter interface {
 //	@Description	Метод удаления пользователя. Принимает на вход id пользователя.
 //	@Tags			user
 //	@Produce		json
+//	@Security		ApiKeyAuth
 //	@Param			userID	path	int	true	"user id"
 //	@Success		200
 //	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
 //	@Failure		500		{object}	handlers.responseError	"error"
 //	@Failure		default	{object}	handlers.responseError	"error"
 //	@Router			/user/{userID} [delete]
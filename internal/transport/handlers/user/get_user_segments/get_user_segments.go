@@ -8,24 +8,30 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/model"
 	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
 )
 
 type segmentsGetter interface {
 	GetUserSegments(context.Context, uint64) ([]string, error)
+	GetUserSegmentsDetailed(context.Context, uint64) ([]*model.UserSegment, error)
 }
 
 // GetUserSegments godoc
 //
 //	@Summary		Получить активные сегменты пользователя
-//	@Description	Метод получения активных сегментов пользователя. Принимает на вход id пользователя.
+//	@Description	Метод получения активных сегментов пользователя. Принимает на вход id пользователя. С параметром detailed=true для каждого сегмента возвращаются также время его добавления пользователю и время истечения TTL.
 //	@Tags			user
 //	@Produce		json
-//	@Param			userID	path		int						true	"user id"
-//	@Success		200		{array}		string					"list of segments"
-//	@Failure		400		{object}	handlers.responseError	"error"
-//	@Failure		500		{object}	handlers.responseError	"error"
-//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Security		ApiKeyAuth
+//	@Param			userID		path		int						true	"user id"
+//	@Param			detailed	query		bool					false	"return assignment and expiry time of segments"
+//	@Success		200			{array}		string					"list of segments (list of model.UserSegment if detailed)"
+//	@Failure		400			{object}	handlers.responseError	"error"
+//	@Failure		401			{object}	handlers.responseError	"error"
+//	@Failure		403			{object}	handlers.responseError	"error"
+//	@Failure		500			{object}	handlers.responseError	"error"
+//	@Failure		default		{object}	handlers.responseError	"error"
 //	@Router			/user-segments/{userID} [get]
 func New(service segmentsGetter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -37,9 +43,15 @@ func New(service segmentsGetter) http.HandlerFunc {
 			handlers.WriteJSONError(w, http.StatusBadRequest, "invalid user id")
 			return
 		}
+		detailed, err := strconv.ParseBool(r.URL.Query().Get("detailed"))
+		if err != nil && r.URL.Query().Has("detailed") {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, "detailed must be either true or false")
+			return
+		}
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
-		segments, err := service.GetUserSegments(ctx, userID)
+		segments, err := getSegments(ctx, service, userID, detailed)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
@@ -51,3 +63,10 @@ func New(service segmentsGetter) http.HandlerFunc {
 		}
 	}
 }
+
+func getSegments(ctx context.Context, service segmentsGetter, userID uint64, detailed bool) (any, error) {
+	if detailed {
+		return service.GetUserSegmentsDetailed(ctx, userID)
+	}
+	return service.GetUserSegments(ctx, userID)
+}
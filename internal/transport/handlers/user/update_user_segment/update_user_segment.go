@@ -0,0 +1,138 @@
+package update_user_segment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+	"github.com/kiryu-dev/segments-api/internal/transport/validation"
+)
+
+type ttlUpdater interface {
+	SetDeleteTime(context.Context, *model.UserSegment) error
+	ExtendDeleteTime(context.Context, *model.UserSegment, string) error
+}
+
+type request struct {
+	TTL       *string    `json:"ttl"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	ExtendBy  *string    `json:"extend_by"`
+	Clear     bool       `json:"clear"`
+}
+
+// UpdateUserSegment godoc
+//
+//	@Summary		Изменить TTL сегмента пользователя
+//	@Description	Метод изменения времени истечения сегмента пользователя без его удаления и повторного добавления. Нужно указать ровно одно из полей: ttl — новый TTL от текущего момента в формате "1y8m21d", expires_at — точное время истечения в формате RFC3339, extend_by — продление текущего TTL (или от текущего момента, если TTL не задан) в формате "1y8m21d", clear — убрать TTL.
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			userID	path		int						true	"user id"
+//	@Param			slug	path		string					true	"segment name"
+//	@Param			input	body		request					true	"new ttl, expiry time, ttl extension or clear flag"
+//	@Success		200		{object}	model.UserSegment		"updated segment of user"
+//	@Failure		400		{object}	handlers.responseError	"error"
+//	@Failure		401		{object}	handlers.responseError	"error"
+//	@Failure		403		{object}	handlers.responseError	"error"
+//	@Failure		404		{object}	handlers.responseError	"error"
+//	@Failure		500		{object}	handlers.responseError	"error"
+//	@Failure		default	{object}	handlers.responseError	"error"
+//	@Router			/user-segments/{userID}/{slug} [patch]
+func New(service ttlUpdater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		vars := mux.Vars(r)
+		userID, err := strconv.ParseUint(vars["userID"], 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, "invalid user id")
+			return
+		}
+		data := new(request)
+		err = json.NewDecoder(r.Body).Decode(data)
+		defer r.Body.Close()
+		if err != nil || data.fieldsCount() != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest,
+				"exactly one of ttl, expires_at, extend_by and clear should be specified")
+			return
+		}
+		seg := &model.UserSegment{
+			UserID: userID,
+			Slug:   vars["slug"],
+		}
+		extendBy, err := data.apply(seg)
+		if errors.Is(err, validation.ErrRegexpErr) {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			handlers.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		if data.ExtendBy != nil {
+			err = service.ExtendDeleteTime(ctx, seg, extendBy)
+		} else {
+			err = service.SetDeleteTime(ctx, seg)
+		}
+		if errors.Is(err, repository.ErrForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+			handlers.WriteJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, repository.ErrNoSegment) {
+			w.WriteHeader(http.StatusNotFound)
+			handlers.WriteJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(seg); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			handlers.WriteServerError(w, http.StatusInternalServerError)
+		}
+	}
+}
+
+// apply sets the new delete time of seg requested by r. If the current delete
+// time has to be extended instead, it returns the ttl to extend it by.
+func (r *request) apply(seg *model.UserSegment) (string, error) {
+	if r.ExtendBy != nil {
+		ttl, err := validation.ValidateTTL(*r.ExtendBy)
+		if err != nil {
+			return "", err
+		}
+		return ttl.String(), nil
+	}
+	if r.Clear {
+		return "", nil
+	}
+	deleteTime, err := validation.ValidateExpiry(r.TTL, r.ExpiresAt)
+	seg.DeleteTime = deleteTime
+	return "", err
+}
+
+func (r *request) fieldsCount() int {
+	count := 0
+	for _, set := range []bool{r.TTL != nil, r.ExpiresAt != nil, r.ExtendBy != nil, r.Clear} {
+		if set {
+			count++
+		}
+	}
+	return count
+}
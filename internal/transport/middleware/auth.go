@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/kiryu-dev/segments-api/internal/actor"
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+type authenticator interface {
+	Authenticate(context.Context, string) (*model.APIKey, error)
+}
+
+// Auth returns a middleware which lets through only requests with an api key
+// granted the scope. The key becomes the actor of the request.
+func Auth(auth authenticator, scope model.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get(apiKeyHeader)
+			if token == "" {
+				token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			}
+			if token == "" {
+				writeError(w, http.StatusUnauthorized, "api key is required")
+				return
+			}
+			key, err := auth.Authenticate(r.Context(), token)
+			if errors.Is(err, repository.ErrKeyNotExists) {
+				writeError(w, http.StatusUnauthorized, "invalid api key")
+				return
+			}
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				handlers.WriteServerError(w, http.StatusInternalServerError)
+				return
+			}
+			if !key.HasScope(scope) {
+				writeError(w, http.StatusForbidden, "api key doesn't have scope "+string(scope))
+				return
+			}
+			ctx := actor.With(r.Context(), &actor.Actor{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Admin:     key.HasScope(model.ScopeAdmin),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	handlers.WriteJSONError(w, status, msg)
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kiryu-dev/segments-api/internal/actor"
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/kiryu-dev/segments-api/internal/transport/handlers"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	idempotencyKeyMaxLen = 255
+)
+
+type idempotencyStore interface {
+	Begin(context.Context, *model.IdempotentRequest) (*model.IdempotentRequest, error)
+	Complete(context.Context, *model.IdempotentRequest) error
+	Abort(context.Context, *model.IdempotentRequest) error
+}
+
+// Idempotency returns a middleware which executes requests with the same
+// Idempotency-Key header only once and replays the stored response for
+// retries. Keys are scoped by the actor, so it should be wrapped by Auth.
+func Idempotency(store idempotencyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > idempotencyKeyMaxLen {
+				writeError(w, http.StatusBadRequest, "idempotency key is too long")
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "cannot read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			req := &model.IdempotentRequest{
+				Actor:       actor.Name(r.Context()),
+				Key:         key,
+				Fingerprint: fingerprint(r, body),
+			}
+			stored, err := store.Begin(r.Context(), req)
+			if errors.Is(err, repository.ErrKeyReused) {
+				writeError(w, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+			if errors.Is(err, repository.ErrRequestInProgress) {
+				writeError(w, http.StatusConflict, err.Error())
+				return
+			}
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				handlers.WriteServerError(w, http.StatusInternalServerError)
+				return
+			}
+			if stored != nil {
+				w.Header().Set("Content-Type", stored.ContentType)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				_, _ = w.Write(stored.Body)
+				return
+			}
+			var (
+				recorder = &responseRecorder{ResponseWriter: w}
+				served   = false
+			)
+			defer func() {
+				if !served {
+					// the handler has panicked, the key is released while
+					// the panic goes on, so that the request can be retried
+					finish(r.Context(), store, req, nil)
+				}
+			}()
+			next.ServeHTTP(recorder, r)
+			served = true
+			finish(r.Context(), store, req, recorder)
+		})
+	}
+}
+
+// finish stores the recorded response for retries. Failed requests and
+// requests without response aren't replayed, so their key is released.
+func finish(ctx context.Context, store idempotencyStore, req *model.IdempotentRequest,
+	recorder *responseRecorder) {
+	// the response is saved even if the client has gone, that's when it retries
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	var err error
+	if recorder == nil || recorder.status >= http.StatusInternalServerError {
+		err = store.Abort(ctx, req)
+	} else {
+		req.Status = recorder.status
+		if req.Status == 0 {
+			// net/http responds with 200 to a handler which writes nothing
+			req.Status = http.StatusOK
+		}
+		req.ContentType = recorder.Header().Get("Content-Type")
+		req.Body = recorder.body.Bytes()
+		err = store.Complete(ctx, req)
+	}
+	if err != nil {
+		log.Printf("cannot save response for idempotency key %s: %v", req.Key, err)
+	}
+}
+
+// fingerprint identifies the request by its method, path and body.
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes the response through keeping its status and body.
+// Only the first status is kept, as the handler may try to write another one
+// after the body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status != 0 {
+		return
+	}
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kiryu-dev/segments-api/internal/model"
+	"github.com/kiryu-dev/segments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStore keeps requests in memory the way the idempotency service does.
+type fakeStore struct {
+	mu       sync.Mutex
+	requests map[string]*model.IdempotentRequest
+	aborted  int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{requests: make(map[string]*model.IdempotentRequest)}
+}
+
+func (s *fakeStore) Begin(_ context.Context, req *model.IdempotentRequest) (*model.IdempotentRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.requests[req.Key]
+	if !ok {
+		reserved := *req
+		s.requests[req.Key] = &reserved
+		return nil, nil
+	}
+	if stored.Fingerprint != req.Fingerprint {
+		return nil, repository.ErrKeyReused
+	}
+	if stored.Status == 0 {
+		return nil, repository.ErrRequestInProgress
+	}
+	return stored, nil
+}
+
+func (s *fakeStore) Complete(_ context.Context, req *model.IdempotentRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	completed := *req
+	s.requests[req.Key] = &completed
+	return nil
+}
+
+func (s *fakeStore) Abort(_ context.Context, req *model.IdempotentRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.requests, req.Key)
+	s.aborted++
+	return nil
+}
+
+func serve(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/segment", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_Idempotency_Replay(t *testing.T) {
+	var (
+		store   = newFakeStore()
+		calls   = 0
+		handler = Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id":1}`))
+		}))
+	)
+	first := serve(handler, "key", `{"slug":"test"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	retry := serve(handler, "key", `{"slug":"test"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+	assert.Equal(t, `{"id":1}`, retry.Body.String())
+	assert.Equal(t, 1, calls)
+
+	serve(handler, "", `{"slug":"test"}`)
+	serve(handler, "other", `{"slug":"test"}`)
+	assert.Equal(t, 3, calls)
+}
+
+func Test_Idempotency_Conflicts(t *testing.T) {
+	store := newFakeStore()
+	store.requests["in-progress"] = &model.IdempotentRequest{
+		Key:         "in-progress",
+		Fingerprint: fingerprint(httptest.NewRequest(http.MethodPost, "/segment", nil), []byte("body")),
+	}
+	handler := Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	assert.Equal(t, http.StatusConflict, serve(handler, "in-progress", "body").Code)
+
+	assert.Equal(t, http.StatusCreated, serve(handler, "key", "body").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, serve(handler, "key", "another body").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(handler, strings.Repeat("k", idempotencyKeyMaxLen+1), "body").Code)
+}
+
+func Test_Idempotency_Release(t *testing.T) {
+	type testCase struct {
+		name    string
+		handler http.HandlerFunc
+		panics  bool
+		stored  bool
+		status  int
+	}
+	testCases := []testCase{
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+		},
+		{
+			name: "panic",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("handler failed")
+			},
+			panics: true,
+		},
+		{
+			name:    "no response",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			stored:  true,
+			status:  http.StatusOK,
+		},
+		{
+			name: "body without status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+			},
+			stored: true,
+			status: http.StatusOK,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				store   = newFakeStore()
+				handler = Idempotency(store)(test.handler)
+			)
+			if test.panics {
+				assert.Panics(t, func() { serve(handler, "key", "body") })
+			} else {
+				serve(handler, "key", "body")
+			}
+			stored, ok := store.requests["key"]
+			assert.Equal(t, test.stored, ok)
+			if test.stored {
+				assert.Equal(t, test.status, stored.Status)
+			} else {
+				assert.Equal(t, 1, store.aborted)
+			}
+		})
+	}
+}
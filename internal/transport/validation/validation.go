@@ -3,17 +3,39 @@ package validation
 import (
 	"fmt"
 	"regexp"
+	"time"
 
+	"github.com/kiryu-dev/segments-api/internal/model"
 	"github.com/kiryu-dev/segments-api/pkg/util/parser"
 )
 
-const slugMaxSize = 32
+const (
+	slugMaxSize        = 32
+	keyNameMaxSize     = 64
+	descriptionMaxSize = 1024
+	tagsMaxCount       = 16
+)
+
+var namespaceRegexp = regexp.MustCompile(`^\w+$`)
 
 var (
 	ErrInvalidSize       = fmt.Errorf("segment name must be less than %d characters long", slugMaxSize)
 	ErrInvalidChar       = fmt.Errorf("segment name must consist only word character (alphanumeric & underscore)")
 	ErrRegexpErr         = fmt.Errorf("unexpected regexp error")
 	ErrInvalidPercentage = fmt.Errorf("user percentage should be between 0 and 100")
+	ErrExpiryConflict    = fmt.Errorf("either ttl or expires_at should be specified, not both")
+	ErrExpiryInPast      = fmt.Errorf("expires_at should be in the future")
+	ErrStartInPast       = fmt.Errorf("starts_at should be in the future")
+	ErrExpiryBeforeStart = fmt.Errorf("expires_at should be after starts_at")
+	ErrInvalidRollout    = fmt.Errorf("rollout mode should be either %q or %q", model.RandomRollout, model.HashRollout)
+	ErrInvalidState      = fmt.Errorf("segment state should be one of %q, %q, %q", model.DraftState, model.ActiveState, model.ArchivedState)
+	ErrInvalidDesc       = fmt.Errorf("segment description must be less than %d characters long", descriptionMaxSize)
+	ErrInvalidTags       = fmt.Errorf("segment can have up to %d tags of word characters each", tagsMaxCount)
+	ErrInvalidConflict   = fmt.Errorf("on_conflict should be one of %q, %q, %q", model.ConflictError, model.ConflictIgnore, model.ConflictRefresh)
+	ErrInvalidNamespace  = fmt.Errorf("namespace must consist only word characters and be less than %d characters long", slugMaxSize)
+	ErrInvalidKeyName    = fmt.Errorf("api key name must be from 1 to %d characters long", keyNameMaxSize)
+	ErrInvalidScopes     = fmt.Errorf("api key scopes should be a non-empty list of %q, %q, %q, %q",
+		model.ScopeSegmentsRead, model.ScopeSegmentsWrite, model.ScopeLogsRead, model.ScopeAdmin)
 )
 
 func ValidateSlug(slug string) error {
@@ -48,9 +70,123 @@ func ValidateTTL(ttl string) (*parser.TTL, error) {
 	return nil, fmt.Errorf("invalid ttl format: expected something like this 1y8m16d")
 }
 
+// ValidateExpiry returns delete time of a segment set either by relative ttl
+// or by absolute expiresAt. It's nil if neither of them is specified.
+func ValidateExpiry(ttl *string, expiresAt *time.Time) (*time.Time, error) {
+	return expiryFrom(time.Now(), ttl, expiresAt, ErrExpiryInPast)
+}
+
+// ValidateSchedule returns delete time of a segment which is assigned to
+// user at startsAt. Relative ttl is counted from the start time, expires_at
+// should be after it.
+func ValidateSchedule(startsAt *time.Time, ttl *string, expiresAt *time.Time) (*time.Time, error) {
+	if startsAt == nil {
+		return ValidateExpiry(ttl, expiresAt)
+	}
+	if !startsAt.After(time.Now()) {
+		return nil, ErrStartInPast
+	}
+	return expiryFrom(*startsAt, ttl, expiresAt, ErrExpiryBeforeStart)
+}
+
+func expiryFrom(from time.Time, ttl *string, expiresAt *time.Time, errBefore error) (*time.Time, error) {
+	if ttl != nil && expiresAt != nil {
+		return nil, ErrExpiryConflict
+	}
+	if expiresAt != nil {
+		if !expiresAt.After(from) {
+			return nil, errBefore
+		}
+		return expiresAt, nil
+	}
+	if ttl == nil {
+		return nil, nil
+	}
+	parsed, err := ValidateTTL(*ttl)
+	if err != nil {
+		return nil, err
+	}
+	deleteTime := from.AddDate(parsed.Years, parsed.Months, parsed.Days)
+	return &deleteTime, nil
+}
+
 func ValidatePercentage(percentage float64) error {
 	if percentage < 0 || percentage > 100 {
 		return ErrInvalidPercentage
 	}
 	return nil
 }
+
+func ValidateRollout(rollout model.RolloutMode) error {
+	if rollout != model.RandomRollout && rollout != model.HashRollout {
+		return ErrInvalidRollout
+	}
+	return nil
+}
+
+func ValidateConflictPolicy(policy model.ConflictPolicy) error {
+	switch policy {
+	case model.ConflictError, model.ConflictIgnore, model.ConflictRefresh:
+		return nil
+	}
+	return ErrInvalidConflict
+}
+
+func ValidateState(state model.SegmentState) error {
+	switch state {
+	case model.DraftState, model.ActiveState, model.ArchivedState:
+		return nil
+	}
+	return ErrInvalidState
+}
+
+func ValidateDescription(description string) error {
+	if len(description) > descriptionMaxSize {
+		return ErrInvalidDesc
+	}
+	return nil
+}
+
+func ValidateTags(tags []string) error {
+	if len(tags) > tagsMaxCount {
+		return ErrInvalidTags
+	}
+	for _, tag := range tags {
+		if len(tag) > slugMaxSize || !namespaceRegexp.MatchString(tag) {
+			return ErrInvalidTags
+		}
+	}
+	return nil
+}
+
+// ValidateNamespace checks the owner of segments, empty namespace is allowed.
+func ValidateNamespace(namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	if len(namespace) > slugMaxSize || !namespaceRegexp.MatchString(namespace) {
+		return ErrInvalidNamespace
+	}
+	return nil
+}
+
+func ValidateKeyName(name string) error {
+	if name == "" || len(name) > keyNameMaxSize {
+		return ErrInvalidKeyName
+	}
+	return nil
+}
+
+func ValidateScopes(scopes []model.Scope) error {
+	if len(scopes) == 0 {
+		return ErrInvalidScopes
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case model.ScopeSegmentsRead, model.ScopeSegmentsWrite, model.ScopeLogsRead, model.ScopeAdmin:
+		default:
+			return ErrInvalidScopes
+		}
+	}
+	return nil
+}
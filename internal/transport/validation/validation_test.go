@@ -1 +1,104 @@
 package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ValidateTTL(t *testing.T) {
+	type testCase struct {
+		ttl      string
+		expected string
+		err      bool
+	}
+	testCases := []testCase{
+		{ttl: "1y8m21d", expected: "1y8m21d"},
+		{ttl: "2m", expected: "2m"},
+		{ttl: "1y5d", expected: "1y5d"},
+		{ttl: "0y5d", err: true},
+		{ttl: "12m", err: true},
+		{ttl: "31d", err: true},
+		{ttl: "0d", err: true},
+		{ttl: "0y0m0d", err: true},
+		{ttl: "", err: true},
+		{ttl: "-1d", err: true},
+		{ttl: "1d1y", err: true},
+		{ttl: "1w", err: true},
+	}
+	for _, test := range testCases {
+		ttl, err := ValidateTTL(test.ttl)
+		if test.err {
+			assert.Error(t, err, test.ttl)
+			continue
+		}
+		assert.NoError(t, err, test.ttl)
+		assert.Equal(t, test.expected, ttl.String())
+	}
+}
+
+func Test_ValidateExpiry(t *testing.T) {
+	type testCase struct {
+		ttl       *string
+		expiresAt *time.Time
+		expected  *time.Time
+		err       error
+	}
+	var (
+		now    = time.Now()
+		future = now.Add(time.Hour)
+		past   = now.Add(-time.Hour)
+		month  = "1m"
+		zero   = "0d"
+	)
+	testCases := []testCase{
+		{},
+		{expiresAt: &future, expected: &future},
+		{expiresAt: &past, err: ErrExpiryInPast},
+		{ttl: &month, expiresAt: &future, err: ErrExpiryConflict},
+	}
+	for _, test := range testCases {
+		deleteTime, err := ValidateExpiry(test.ttl, test.expiresAt)
+		assert.ErrorIs(t, err, test.err)
+		assert.Equal(t, test.expected, deleteTime)
+	}
+	deleteTime, err := ValidateExpiry(&month, nil)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, now.AddDate(0, 1, 0), *deleteTime, time.Minute)
+	_, err = ValidateExpiry(&zero, nil)
+	assert.Error(t, err)
+}
+
+func Test_ValidateSchedule(t *testing.T) {
+	type testCase struct {
+		startsAt  *time.Time
+		ttl       *string
+		expiresAt *time.Time
+		expected  *time.Time
+		err       error
+	}
+	var (
+		now      = time.Now()
+		past     = now.Add(-time.Hour)
+		start    = now.Add(24 * time.Hour)
+		beforeIt = now.Add(time.Hour)
+		afterIt  = now.Add(48 * time.Hour)
+		day      = "1d"
+		dayAfter = start.AddDate(0, 0, 1)
+	)
+	testCases := []testCase{
+		{startsAt: &start},
+		{startsAt: &past, err: ErrStartInPast},
+		{startsAt: &start, ttl: &day, expected: &dayAfter},
+		{startsAt: &start, expiresAt: &afterIt, expected: &afterIt},
+		{startsAt: &start, expiresAt: &beforeIt, err: ErrExpiryBeforeStart},
+		{expiresAt: &past, err: ErrExpiryInPast},
+		{expiresAt: &afterIt, expected: &afterIt},
+	}
+	for _, test := range testCases {
+		deleteTime, err := ValidateSchedule(test.startsAt, test.ttl, test.expiresAt)
+		assert.ErrorIs(t, err, test.err)
+		assert.Equal(t, test.expected, deleteTime)
+	}
+}
@@ -0,0 +1,10 @@
+package batch
+
+// Split divides values into consecutive batches of at most size elements.
+func Split[T any](values []T, size int) [][]T {
+	batches := make([][]T, 0, (len(values)+size-1)/size)
+	for start := 0; start < len(values); start += size {
+		batches = append(batches, values[start:min(start+size, len(values))])
+	}
+	return batches
+}
@@ -0,0 +1,40 @@
+package batch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Split(t *testing.T) {
+	type testCase struct {
+		values   []int
+		size     int
+		expected [][]int
+	}
+	testCases := []testCase{
+		{
+			values:   []int{},
+			size:     2,
+			expected: [][]int{},
+		},
+		{
+			values:   []int{1, 2, 3, 4},
+			size:     2,
+			expected: [][]int{{1, 2}, {3, 4}},
+		},
+		{
+			values:   []int{1, 2, 3, 4, 5},
+			size:     2,
+			expected: [][]int{{1, 2}, {3, 4}, {5}},
+		},
+		{
+			values:   []int{1, 2, 3},
+			size:     10,
+			expected: [][]int{{1, 2, 3}},
+		},
+	}
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, Split(test.values, test.size))
+	}
+}
@@ -0,0 +1,34 @@
+package bucket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+)
+
+const (
+	buckets  = 10000
+	saltSize = 8
+)
+
+// Bucket maps user to a stable point in [0, 100) with 0.01 precision.
+// Different salts give independent distributions of the same users.
+func Bucket(userID uint64, salt string) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(salt))
+	_ = binary.Write(h, binary.BigEndian, userID)
+	return float64(h.Sum64()%buckets) / (buckets / 100)
+}
+
+func Contains(userID uint64, salt string, percentage float64) bool {
+	return Bucket(userID, salt) < percentage
+}
+
+func NewSalt() (string, error) {
+	buf := make([]byte, saltSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
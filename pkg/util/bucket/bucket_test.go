@@ -0,0 +1,48 @@
+package bucket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Bucket(t *testing.T) {
+	for id := uint64(0); id < 1000; id++ {
+		b := Bucket(id, "salt")
+		assert.GreaterOrEqual(t, b, 0.)
+		assert.Less(t, b, 100.)
+		assert.Equal(t, b, Bucket(id, "salt"))
+	}
+}
+
+func Test_Contains(t *testing.T) {
+	type testCase struct {
+		percentage float64
+		min, max   int
+	}
+	testCases := []testCase{
+		{percentage: 0, min: 0, max: 0},
+		{percentage: 30, min: 2800, max: 3200},
+		{percentage: 75.5, min: 7350, max: 7750},
+		{percentage: 100, min: 10000, max: 10000},
+	}
+	for _, test := range testCases {
+		count := 0
+		for id := uint64(1); id <= 10000; id++ {
+			if Contains(id, "0123456789abcdef", test.percentage) {
+				count++
+			}
+		}
+		assert.GreaterOrEqual(t, count, test.min)
+		assert.LessOrEqual(t, count, test.max)
+	}
+}
+
+func Test_NewSalt(t *testing.T) {
+	lhs, err := NewSalt()
+	assert.NoError(t, err)
+	rhs, err := NewSalt()
+	assert.NoError(t, err)
+	assert.Len(t, lhs, 2*saltSize)
+	assert.NotEqual(t, lhs, rhs)
+}
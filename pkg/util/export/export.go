@@ -0,0 +1,248 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported export format")
+
+// parquetRowGroupSize limits the number of records parquet writer buffers in
+// memory, every row group is written to the stream as soon as it's full.
+const parquetRowGroupSize = 10000
+
+// Writer writes records one by one to the underlying stream. Close must be
+// called after the last record to flush buffered data, it doesn't close the
+// stream itself.
+type Writer[T any] interface {
+	Write(*T) error
+	Close() error
+}
+
+// Exporter describes an export format and creates writers of it.
+type Exporter[T any] interface {
+	Name() string
+	ContentType() string
+	Extension() string
+	NewWriter(io.Writer) Writer[T]
+}
+
+// Record is implemented by types which can be exported to CSV.
+type Record interface {
+	CSVHeader() []string
+	CSVRecord() []string
+}
+
+type exporter[T any] struct {
+	name        string
+	contentType string
+	extension   string
+	newWriter   func(io.Writer) Writer[T]
+}
+
+func (e *exporter[T]) Name() string                    { return e.name }
+func (e *exporter[T]) ContentType() string             { return e.contentType }
+func (e *exporter[T]) Extension() string               { return e.extension }
+func (e *exporter[T]) NewWriter(w io.Writer) Writer[T] { return e.newWriter(w) }
+
+// CSV exports records in RFC 4180 format with a header row.
+func CSV[T Record]() Exporter[T] {
+	return &exporter[T]{
+		name:        "csv",
+		contentType: "text/csv",
+		extension:   "csv",
+		newWriter: func(w io.Writer) Writer[T] {
+			return &csvWriter[T]{writer: csv.NewWriter(w)}
+		},
+	}
+}
+
+// JSON exports records as a single JSON array.
+func JSON[T any]() Exporter[T] {
+	return &exporter[T]{
+		name:        "json",
+		contentType: "application/json",
+		extension:   "json",
+		newWriter: func(w io.Writer) Writer[T] {
+			return &jsonWriter[T]{w: w}
+		},
+	}
+}
+
+// NDJSON exports records as JSON objects separated by newlines.
+func NDJSON[T any]() Exporter[T] {
+	return &exporter[T]{
+		name:        "ndjson",
+		contentType: "application/x-ndjson",
+		extension:   "ndjson",
+		newWriter: func(w io.Writer) Writer[T] {
+			return &ndjsonWriter[T]{encoder: json.NewEncoder(w)}
+		},
+	}
+}
+
+// Parquet exports records in Apache Parquet format. Columns are defined by
+// parquet struct tags of T.
+func Parquet[T any]() Exporter[T] {
+	return &exporter[T]{
+		name:        "parquet",
+		contentType: "application/vnd.apache.parquet",
+		extension:   "parquet",
+		newWriter: func(w io.Writer) Writer[T] {
+			writer := parquet.NewGenericWriter[T](w, parquet.MaxRowsPerRowGroup(parquetRowGroupSize))
+			return &parquetWriter[T]{writer: writer}
+		},
+	}
+}
+
+// Negotiate picks the exporter by its name if format is specified, otherwise
+// by the Accept header. The first exporter is the default one.
+func Negotiate[T any](exporters []Exporter[T], format, accept string) (Exporter[T], error) {
+	if format != "" {
+		for _, e := range exporters {
+			if e.Name() == format {
+				return e, nil
+			}
+		}
+		return nil, ErrUnsupportedFormat
+	}
+	if accept == "" {
+		return exporters[0], nil
+	}
+	for _, mediaType := range parseAccept(accept) {
+		if mediaType == "*/*" {
+			return exporters[0], nil
+		}
+		for _, e := range exporters {
+			if e.ContentType() == mediaType {
+				return e, nil
+			}
+		}
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// parseAccept returns media types of the Accept header ordered by quality.
+func parseAccept(accept string) []string {
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	ranges := make([]mediaRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, mediaRange{mediaType, quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	mediaTypes := make([]string, len(ranges))
+	for i, r := range ranges {
+		mediaTypes[i] = r.mediaType
+	}
+	return mediaTypes
+}
+
+type csvWriter[T Record] struct {
+	writer     *csv.Writer
+	withHeader bool
+}
+
+func (w *csvWriter[T]) Write(record *T) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.writer.Write((*record).CSVRecord())
+}
+
+func (w *csvWriter[T]) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvWriter[T]) writeHeader() error {
+	if w.withHeader {
+		return nil
+	}
+	w.withHeader = true
+	var record T
+	return w.writer.Write(record.CSVHeader())
+}
+
+type jsonWriter[T any] struct {
+	w       io.Writer
+	written bool
+}
+
+func (w *jsonWriter[T]) Write(record *T) error {
+	delim := ","
+	if !w.written {
+		delim = "["
+		w.written = true
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w.w, delim); err != nil {
+		return err
+	}
+	_, err = w.w.Write(data)
+	return err
+}
+
+func (w *jsonWriter[T]) Close() error {
+	end := "]\n"
+	if !w.written {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(w.w, end)
+	return err
+}
+
+type ndjsonWriter[T any] struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonWriter[T]) Write(record *T) error {
+	return w.encoder.Encode(record)
+}
+
+func (w *ndjsonWriter[T]) Close() error {
+	return nil
+}
+
+type parquetWriter[T any] struct {
+	writer *parquet.GenericWriter[T]
+}
+
+func (w *parquetWriter[T]) Write(record *T) error {
+	_, err := w.writer.Write([]T{*record})
+	return err
+}
+
+func (w *parquetWriter[T]) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,126 @@
+package export
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRecord struct {
+	ID   int64  `json:"id" parquet:"id"`
+	Name string `json:"name" parquet:"name"`
+}
+
+func (r testRecord) CSVHeader() []string {
+	return []string{"id", "name"}
+}
+
+func (r testRecord) CSVRecord() []string {
+	return []string{strconv.FormatInt(r.ID, 10), r.Name}
+}
+
+var records = []*testRecord{
+	{ID: 1, Name: "first"},
+	{ID: 2, Name: "with, comma"},
+}
+
+func write(t *testing.T, exporter Exporter[testRecord], records []*testRecord) []byte {
+	var (
+		buf    = new(bytes.Buffer)
+		writer = exporter.NewWriter(buf)
+	)
+	for _, record := range records {
+		assert.NoError(t, writer.Write(record))
+	}
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func Test_Exporters(t *testing.T) {
+	type testCase struct {
+		exporter Exporter[testRecord]
+		records  []*testRecord
+		expected string
+	}
+	testCases := []testCase{
+		{
+			exporter: CSV[testRecord](),
+			records:  records,
+			expected: "id,name\n1,first\n2,\"with, comma\"\n",
+		},
+		{
+			exporter: CSV[testRecord](),
+			records:  nil,
+			expected: "id,name\n",
+		},
+		{
+			exporter: JSON[testRecord](),
+			records:  records,
+			expected: `[{"id":1,"name":"first"},{"id":2,"name":"with, comma"}]` + "\n",
+		},
+		{
+			exporter: JSON[testRecord](),
+			records:  nil,
+			expected: "[]\n",
+		},
+		{
+			exporter: NDJSON[testRecord](),
+			records:  records,
+			expected: `{"id":1,"name":"first"}` + "\n" + `{"id":2,"name":"with, comma"}` + "\n",
+		},
+	}
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, string(write(t, test.exporter, test.records)))
+	}
+}
+
+func Test_Parquet(t *testing.T) {
+	data := write(t, Parquet[testRecord](), records)
+	read, err := parquet.Read[testRecord](bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, []testRecord{*records[0], *records[1]}, read)
+}
+
+func Test_ParquetRowGroups(t *testing.T) {
+	records := make([]*testRecord, 2*parquetRowGroupSize+1)
+	for i := range records {
+		records[i] = &testRecord{ID: int64(i), Name: strconv.Itoa(i)}
+	}
+	data := write(t, Parquet[testRecord](), records)
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	assert.Len(t, file.RowGroups(), 3)
+	assert.Equal(t, int64(len(records)), file.NumRows())
+}
+
+func Test_Negotiate(t *testing.T) {
+	type testCase struct {
+		format   string
+		accept   string
+		expected string
+		err      error
+	}
+	exporters := []Exporter[testRecord]{
+		CSV[testRecord](), JSON[testRecord](), NDJSON[testRecord](), Parquet[testRecord](),
+	}
+	testCases := []testCase{
+		{expected: "csv"},
+		{format: "ndjson", accept: "text/csv", expected: "ndjson"},
+		{format: "xml", err: ErrUnsupportedFormat},
+		{accept: "application/json", expected: "json"},
+		{accept: "*/*", expected: "csv"},
+		{accept: "text/csv;q=0.5, application/vnd.apache.parquet", expected: "parquet"},
+		{accept: "application/xml, application/x-ndjson;q=0.1", expected: "ndjson"},
+		{accept: "application/xml", err: ErrUnsupportedFormat},
+	}
+	for _, test := range testCases {
+		exporter, err := Negotiate(exporters, test.format, test.accept)
+		assert.ErrorIs(t, err, test.err)
+		if test.err == nil {
+			assert.Equal(t, test.expected, exporter.Name())
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -12,6 +15,21 @@ type TTL struct {
 	Days   int
 }
 
+// String returns ttl in the same format it's parsed from, e.g. "1y8m21d".
+func (t *TTL) String() string {
+	result := ""
+	if t.Years != 0 {
+		result += fmt.Sprintf("%dy", t.Years)
+	}
+	if t.Months != 0 {
+		result += fmt.Sprintf("%dm", t.Months)
+	}
+	if t.Days != 0 {
+		result += fmt.Sprintf("%dd", t.Days)
+	}
+	return result
+}
+
 func ParseResponse(buf []byte) (lhs uint64, rhs string) {
 	if len(buf) < 2 {
 		return
@@ -60,3 +78,30 @@ func ParseTTL(ttl string) (result *TTL, err error) {
 	}
 	return
 }
+
+// ParseIDs reads ids from the first column of CSV data. The first line is
+// treated as a header if it isn't a number.
+func ParseIDs(r io.Reader) ([]uint64, error) {
+	var (
+		reader = csv.NewReader(r)
+		ids    = make([]uint64, 0)
+	)
+	reader.FieldsPerRecord = -1
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid csv: %v", err)
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(record[0]), 10, 64)
+		if err != nil && line == 1 {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid id at line %d: %s", line, record[0])
+		}
+		ids = append(ids, id)
+	}
+}
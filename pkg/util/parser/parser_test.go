@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -101,3 +102,64 @@ func Test_ParseTTL(t *testing.T) {
 		assert.Equal(t, test.expected, ttl)
 	}
 }
+
+func Test_TTLString(t *testing.T) {
+	type testCase struct {
+		input    *TTL
+		expected string
+	}
+	testCases := []testCase{
+		{
+			input:    &TTL{Years: 1, Months: 2, Days: 9},
+			expected: "1y2m9d",
+		},
+		{
+			input:    &TTL{Years: 64},
+			expected: "64y",
+		},
+		{
+			input:    &TTL{Months: 8, Days: 24},
+			expected: "8m24d",
+		},
+		{
+			input:    &TTL{},
+			expected: "",
+		},
+	}
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, test.input.String())
+	}
+}
+
+func Test_ParseIDs(t *testing.T) {
+	type testCase struct {
+		input    string
+		expected []uint64
+	}
+	testCases := []testCase{
+		{
+			input:    "",
+			expected: []uint64{},
+		},
+		{
+			input:    "user_id\n1\n2\n3\n",
+			expected: []uint64{1, 2, 3},
+		},
+		{
+			input:    "1000,ignored\n 2001 \n",
+			expected: []uint64{1000, 2001},
+		},
+		{
+			input:    "user_id\n1\nuser\n",
+			expected: nil,
+		},
+		{
+			input:    "1\n-2\n",
+			expected: nil,
+		},
+	}
+	for _, test := range testCases {
+		ids, _ := ParseIDs(strings.NewReader(test.input))
+		assert.Equal(t, test.expected, ids)
+	}
+}
@@ -21,3 +21,14 @@ func Select(values []uint64, count int) ([]uint64, error) {
 	}
 	return values[(n - count):], nil
 }
+
+// Draw reports whether a random event with the given probability
+// (in percent) has happened.
+func Draw(percentage float64) (bool, error) {
+	const precision = 10000
+	n, err := rand.Int(rand.Reader, big.NewInt(precision))
+	if err != nil {
+		return false, err
+	}
+	return float64(n.Int64()) < percentage/100.*precision, nil
+}